@@ -21,11 +21,11 @@ type evalCommand struct {
 }
 
 func (cmd *evalCommand) Validate() error {
-	err := validateRuleOrGroupName("group", cmd.Group)
+	err := protocol.ValidateName("group", cmd.Group)
 	if err != nil {
 		return err
 	}
-	return validateRuleOrGroupName("rule", cmd.Rule)
+	return protocol.ValidateName("rule", cmd.Rule)
 }
 
 func (cmd *evalCommand) Exec(cmdID string, logger log.Printer, ins io.Reader, _ io.Writer, r *bufio.Reader, w *bufio.Writer) error {
@@ -99,7 +99,6 @@ func (cmd *evalCommand) Exec(cmdID string, logger log.Printer, ins io.Reader, _
 			log.Verbose(logger, "received %d bytes of unexpected data in success result: %q", len(rst.Data), rst.Data)
 			return errors.New("unexpected data in success result")
 		}
-		return nil
 	case protocol.ErrorResultType:
 		return errors.New(string(rst.Data))
 	default:
@@ -107,24 +106,6 @@ func (cmd *evalCommand) Exec(cmdID string, logger log.Printer, ins io.Reader, _
 	}
 }
 
-// TODO: This should be specific to validating rule and group and should
-// ideally be shared code that daemon can also use.
-func validateRuleOrGroupName(category, name string) error {
-	for i := range name {
-		c := name[i]
-		if 'a' <= c && c <= 'z' ||
-			'A' <= c && c <= 'Z' ||
-			'0' <= c && c <= '9' ||
-			c == '_' || c == '-' {
-			continue
-		} else {
-			return fmt.Errorf("%s contains invalid character '%c'. "+
-				"valid characters are [a-zA-Z0-9_-]", category, c)
-		}
-	}
-	return nil
-}
-
 type errNoMatch int
 
 func (err errNoMatch) Error() string {
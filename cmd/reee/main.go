@@ -18,8 +18,10 @@ import (
 
 type args struct {
 	// Sub-commands.
-	EvalCommand *evalCommand `arg:"subcommand:eval"`
-	ListCommand *listCommand `arg:"subcommand:list"`
+	EvalCommand  *evalCommand  `arg:"subcommand:eval"`
+	ListCommand  *listCommand  `arg:"subcommand:list"`
+	ResetCommand *resetCommand `arg:"subcommand:reset"`
+	TrainCommand *trainCommand `arg:"subcommand:train"`
 
 	// Global arguments.
 	Address string `arg:"--addr,env:REEE_ADDR" help:"daemon address"`
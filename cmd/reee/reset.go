@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gogama/reee-evolution/log"
+	"github.com/gogama/reee-evolution/protocol"
+)
+
+type resetCommand struct {
+	Group string `arg:"positional" help:"optional rule group to reset"`
+	Rule  string `arg:"positional" help:"optional rule to reset within group"`
+}
+
+func (cmd *resetCommand) Validate() error {
+	if cmd.Group == "" && cmd.Rule != "" {
+		return errors.New("rule requires a group")
+	}
+	err := protocol.ValidateName("group", cmd.Group)
+	if err != nil {
+		return err
+	}
+	return protocol.ValidateName("rule", cmd.Rule)
+}
+
+func (cmd *resetCommand) Exec(cmdID string, logger log.Printer, _ io.Reader, outs io.Writer, r *bufio.Reader, w *bufio.Writer) error {
+	args := cmd.Group
+	if cmd.Rule != "" {
+		args += " " + cmd.Rule
+	}
+	pc := protocol.Command{
+		Type:  protocol.ResetCommandType,
+		ID:    cmdID,
+		Level: log.LevelOf(logger),
+		Args:  args,
+	}
+
+	start := time.Now()
+	err := protocol.WriteCommand(w, pc)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	log.Verbose(logger, "wrote %s command for cmd %s in %s.", protocol.ResetCommandType, cmdID, elapsed)
+
+	start = time.Now()
+	rst, err := protocol.ReadResult(logger, r)
+	if err != nil {
+		return err
+	}
+	elapsed = time.Since(start)
+	log.Verbose(logger, "read %s result and %d bytes of data in %s.", rst.Type, len(rst.Data), elapsed)
+
+	switch rst.Type {
+	case protocol.SuccessResultType:
+		_, err = outs.Write(rst.Data)
+		return err
+	case protocol.ErrorResultType:
+		return errors.New(string(rst.Data))
+	default:
+		panic(fmt.Sprintf("reee: unhandled result type: %d", rst.Type))
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gogama/reee-evolution/log"
+	"github.com/gogama/reee-evolution/protocol"
+)
+
+type trainCommand struct {
+	Classifier string `arg:"positional,required" help:"classifier to train"`
+	TagKey     string `arg:"positional,required" help:"tag key whose values label the stored sampled messages"`
+}
+
+func (cmd *trainCommand) Validate() error {
+	err := protocol.ValidateName("classifier", cmd.Classifier)
+	if err != nil {
+		return err
+	}
+	if strings.IndexFunc(cmd.TagKey, unicode.IsSpace) >= 0 {
+		return errors.New("tag key contains whitespace")
+	}
+	return nil
+}
+
+func (cmd *trainCommand) Exec(cmdID string, logger log.Printer, _ io.Reader, outs io.Writer, r *bufio.Reader, w *bufio.Writer) error {
+	pc := protocol.Command{
+		Type:  protocol.TrainCommandType,
+		ID:    cmdID,
+		Level: log.LevelOf(logger),
+		Args:  cmd.Classifier + " " + cmd.TagKey,
+	}
+
+	start := time.Now()
+	err := protocol.WriteCommand(w, pc)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	log.Verbose(logger, "wrote %s command for cmd %s in %s.", protocol.TrainCommandType, cmdID, elapsed)
+
+	start = time.Now()
+	rst, err := protocol.ReadResult(logger, r)
+	if err != nil {
+		return err
+	}
+	elapsed = time.Since(start)
+	log.Verbose(logger, "read %s result and %d bytes of data in %s.", rst.Type, len(rst.Data), elapsed)
+
+	switch rst.Type {
+	case protocol.SuccessResultType:
+		_, err = outs.Write(rst.Data)
+		return err
+	case protocol.ErrorResultType:
+		return errors.New(string(rst.Data))
+	default:
+		panic(fmt.Sprintf("reee: unhandled result type: %d", rst.Type))
+	}
+}
@@ -26,15 +26,19 @@ import (
 )
 
 type args struct {
-	Address   string  `arg:"-a,--addr,env:REEE_ADDR" help:"listen on address"`
-	Network   string  `arg:"-n,--net,env:REEE_NET" help:"listen on network"`
-	DBFile    string  `arg:"--db,env:REEE_DB" help:"path to email events database" placeholder:"FILE"`
-	NoDB      bool    `arg:"--no-db" help:"don't log events to database"`
-	RulePath  string  `arg:"--rules,env:REEE_RULES" help:"path to rule script directory" placeholder:"DIR"`
-	SamplePct percent `arg:"-s,--sample" help:"sample percentage, e.g. 25%" default:"1%"`
-	RandSeed  *int64  `arg:"-S,--seed" help:"seed for Math.random() number generator"`
-	Quiet     bool    `arg:"-q,--quiet" help:"log only high-importance messages"`
-	Verbose   bool    `arg:"-v,--verbose" help:"log all available messages"`
+	Address         string        `arg:"-a,--addr,env:REEE_ADDR" help:"listen on address"`
+	Network         string        `arg:"-n,--net,env:REEE_NET" help:"listen on network"`
+	DBFile          string        `arg:"--db,env:REEE_DB" help:"path to email events database" placeholder:"FILE"`
+	NoDB            bool          `arg:"--no-db" help:"don't log events to database"`
+	RulePath        string        `arg:"--rules,env:REEE_RULES" help:"path to rule script directory" placeholder:"DIR"`
+	Calendars       []string      `arg:"--calendar,separate" help:"iCalendar file or directory to check for conflicts, may be repeated" placeholder:"PATH"`
+	Contacts        []string      `arg:"--contacts,separate" help:"vCard file or directory of known contacts, may be repeated" placeholder:"PATH"`
+	SamplePct       percent       `arg:"-s,--sample" help:"sample percentage, e.g. 25%" default:"1%"`
+	RandSeed        *int64        `arg:"-S,--seed" help:"seed for Math.random() number generator"`
+	QuarantineAfter int           `arg:"--quarantine-after" help:"quarantine a rule after N consecutive errors, 0 to disable" default:"5" placeholder:"N"`
+	QuarantineFor   time.Duration `arg:"--quarantine-for" help:"how long to quarantine a failing rule" default:"10m"`
+	Quiet           bool          `arg:"-q,--quiet" help:"log only high-importance messages"`
+	Verbose         bool          `arg:"-v,--verbose" help:"log all available messages"`
 }
 
 func (a *args) Version() string {
@@ -100,10 +104,14 @@ func runDaemon(parent context.Context, w io.Writer, start time.Time, a *args) er
 	signalCtx, stop := reeeuse.SignalContext(parent)
 
 	// Load the rules.
-	groups, err := loadRuleGroups(signalCtx, logger, a)
+	groupSet, err := loadRuleGroups(signalCtx, logger, a)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = groupSet.Close(context.Background())
+	}()
+	groups := groupSet.ToMap()
 
 	// Create or open the SQLite3-based persistent store.
 	var s daemon.MessageStore
@@ -157,6 +165,9 @@ func runDaemon(parent context.Context, w io.Writer, start time.Time, a *args) er
 		Store:     s,
 		SampleSrc: rand.NewSource(time.Now().UnixMilli()),
 		SamplePct: float64(a.SamplePct),
+
+		QuarantineAfter: a.QuarantineAfter,
+		QuarantineFor:   a.QuarantineFor,
 	}
 	var fatalErr atomic.Value
 	go func() {
@@ -192,7 +203,7 @@ func runDaemon(parent context.Context, w io.Writer, start time.Time, a *args) er
 	return err
 }
 
-func loadRuleGroups(ctx context.Context, logger log.Printer, a *args) (map[string][]daemon.Rule, error) {
+func loadRuleGroups(ctx context.Context, logger log.Printer, a *args) (*rule.GroupSet, error) {
 	var seedLog string
 	if a.RandSeed == nil {
 		seedLog = "<file load time>"
@@ -209,26 +220,73 @@ func loadRuleGroups(ctx context.Context, logger log.Printer, a *args) (map[strin
 		// TODO: Log warning and fail out.
 	}
 
-	var groups rule.GroupSet
+	groups := &rule.GroupSet{
+		Dir: a.RulePath,
+	}
+
+	// Load the calendars consulted by reee.calendar.conflicts(), if any.
+	if len(a.Calendars) > 0 {
+		log.Normal(logger, "loading calendars...     [paths: %s]", strings.Join(a.Calendars, ", "))
+		cal, err := rule.NewBusyCalendar(logger, a.Calendars)
+		if err != nil {
+			return nil, err
+		}
+		groups.Calendar = cal
+	}
+
+	// Load the address book consulted by reee.contacts, if any.
+	if len(a.Contacts) > 0 {
+		log.Normal(logger, "loading contacts...      [paths: %s]", strings.Join(a.Contacts, ", "))
+		contacts, err := rule.NewContacts(logger, a.Contacts)
+		if err != nil {
+			return nil, err
+		}
+		groups.Contacts = contacts
+	}
 
-	// Find all the JavaScript files and load them.
+	// Load the lists exposed as reee.lists, if the rule directory has a
+	// lists subdirectory.
+	listsPath := filepath.Join(a.RulePath, "lists")
+	if info, err := os.Stat(listsPath); err == nil && info.IsDir() {
+		log.Normal(logger, "loading lists...         [path: %s]", listsPath)
+		lists, err := rule.NewLists(logger, listsPath)
+		if err != nil {
+			return nil, err
+		}
+		groups.Lists = lists
+	}
+
+	// Find all the JavaScript, Starlark, WebAssembly and declarative rule
+	// files and load them.
 	err := filepath.WalkDir(a.RulePath, func(path string, d os.DirEntry, err error) error {
-		if d.IsDir() || !strings.HasSuffix(path, ".js") {
+		if d.IsDir() {
 			return nil
 		}
-		var randSeed int64
-		if a.RandSeed == nil {
-			randSeed = time.Now().Unix()
-		} else {
-			randSeed = *a.RandSeed
+		switch filepath.Ext(path) {
+		case ".js":
+			var randSeed int64
+			if a.RandSeed == nil {
+				randSeed = time.Now().Unix()
+			} else {
+				randSeed = *a.RandSeed
+			}
+			return groups.Load(ctx, logger, path, randSeed)
+		case ".yaml", ".yml", ".json":
+			return groups.LoadDecl(ctx, logger, path)
+		case ".star":
+			return groups.LoadStarlark(ctx, logger, path)
+		case ".wasm":
+			return groups.LoadWasm(ctx, logger, path)
+		default:
+			return nil
 		}
-		return groups.Load(ctx, logger, path, randSeed)
 	})
 	if err != nil {
+		_ = groups.Close(ctx)
 		return nil, err
 	}
 
-	return groups.ToMap(), nil
+	return groups, nil
 }
 
 type percent float64
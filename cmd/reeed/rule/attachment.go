@@ -0,0 +1,166 @@
+package rule
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+	"github.com/jhillyerd/enmime"
+)
+
+// jsAttachmentMaxBytes is the maximum number of bytes of attachment
+// content that bytes() will copy into an ArrayBuffer.
+const jsAttachmentMaxBytes = 16 << 20
+
+func jsAttachmentPrototypeDefineContentProps(cont *vmContainer, proto *goja.Object) error {
+	vm := cont.vm
+	err := defineCachedProperty(vm, proto, "size", func(a *jsAttachment) (goja.Value, error) {
+		return vm.ToValue(len(a.part.Content)), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "sha256", func(a *jsAttachment) (goja.Value, error) {
+		sum := sha256.Sum256(a.part.Content)
+		return vm.ToValue(hex.EncodeToString(sum[:])), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "md5", func(a *jsAttachment) (goja.Value, error) {
+		sum := md5.Sum(a.part.Content)
+		return vm.ToValue(hex.EncodeToString(sum[:])), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "sniffedContentType", func(a *jsAttachment) (goja.Value, error) {
+		return vm.ToValue(sniffContentType(a.part.Content)), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "contentTypeMismatch", func(a *jsAttachment) (goja.Value, error) {
+		return vm.ToValue(contentTypeMismatch(a.part.ContentType, sniffContentType(a.part.Content))), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "text", func(a *jsAttachment) (goja.Value, error) {
+		if !isTextualAttachment(a.part) {
+			return goja.Null(), nil
+		}
+		return vm.ToValue(string(a.part.Content)), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "entries", func(a *jsAttachment) (goja.Value, error) {
+		if sniffContentType(a.part.Content) != "application/zip" {
+			return goja.Null(), nil
+		}
+		remaining := zipMaxEntries
+		return marshalZipEntries(cont, a.part.Content, 1, &remaining)
+	})
+	if err != nil {
+		return err
+	}
+	return proto.Set("bytes", vm.ToValue(jsAttachmentBytes))
+}
+
+// jsAttachmentBytes returns a copy of the attachment content as an
+// ArrayBuffer. The optional argument limits the number of bytes
+// returned. The result is never longer than jsAttachmentMaxBytes.
+func jsAttachmentBytes(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	if len(call.Arguments) > 1 {
+		throwJSException(vm, "reeed: bytes() takes at most one argument")
+	}
+	limit := int64(jsAttachmentMaxBytes)
+	if len(call.Arguments) == 1 && !goja.IsUndefined(call.Arguments[0]) {
+		n_ := call.Arguments[0].Export()
+		n, ok := n_.(int64)
+		if !ok || n < 0 {
+			throwJSException(vm, errUnexpectedArgType(0, int64(0), n_))
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	this_ := call.This.Export()
+	this, ok := this_.(*jsAttachment)
+	if !ok {
+		throwJSException(vm, errUnexpectedThisType(&jsAttachment{}, this_))
+	}
+	content := this.part.Content
+	if int64(len(content)) > limit {
+		content = content[:limit]
+	}
+	b := make([]byte, len(content))
+	copy(b, content)
+	return vm.ToValue(vm.NewArrayBuffer(b))
+}
+
+// sniffSignatures supplements http.DetectContentType with signatures
+// for types it doesn't know about, notably executables.
+var sniffSignatures = []struct {
+	magic       []byte
+	contentType string
+}{
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{[]byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xca\xfe\xba\xbe"), "application/x-mach-binary"},
+	{[]byte("#!"), "text/x-shellscript"},
+	{[]byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), "application/x-ole-storage"},
+	{[]byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{[]byte("\x1f\x8b"), "application/gzip"},
+}
+
+func sniffContentType(content []byte) string {
+	for _, sig := range sniffSignatures {
+		if bytes.HasPrefix(content, sig.magic) {
+			return sig.contentType
+		}
+	}
+	contentType := http.DetectContentType(content)
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return contentType
+}
+
+// contentTypeMismatch reports whether the sniffed content type
+// contradicts the declared one. Generic types on either side, such as
+// application/octet-stream, never contradict anything.
+func contentTypeMismatch(declared, sniffed string) bool {
+	declared = strings.ToLower(declared)
+	switch {
+	case declared == "" || declared == "application/octet-stream":
+		return false
+	case sniffed == "application/octet-stream":
+		return false
+	case sniffed == "text/plain":
+		return !strings.HasPrefix(declared, "text/") && !strings.HasSuffix(declared, "+xml") && !strings.HasSuffix(declared, "/json")
+	case sniffed == "application/zip":
+		// Office documents and many other formats are zip containers.
+		return !strings.Contains(declared, "zip") && !strings.Contains(declared, "openxmlformats") &&
+			!strings.Contains(declared, "opendocument") && !strings.HasPrefix(declared, "application/java")
+	default:
+		return declared != sniffed
+	}
+}
+
+func isTextualAttachment(part *enmime.Part) bool {
+	if isTextualPart(part) {
+		return true
+	}
+	return strings.HasPrefix(sniffContentType(part.Content), "text/") && utf8.Valid(part.Content)
+}
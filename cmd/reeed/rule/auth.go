@@ -0,0 +1,505 @@
+package rule
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/jhillyerd/enmime"
+)
+
+// authResult is one method result from an Authentication-Results,
+// ARC-Authentication-Results or Received-SPF header.
+type authResult struct {
+	header     string            // Name of the header the result came from
+	authservID string            // Authentication service identifier
+	instance   int               // ARC instance number, or zero
+	method     string            // "dkim", "spf", "dmarc", "arc", etc.
+	result     string            // "pass", "fail", "none", etc.
+	reason     string            // Reason, if given
+	props      map[string]string // Properties, e.g. "header.d"
+	domain     string            // Domain the result applies to
+	selector   string            // DKIM selector
+}
+
+// parseAuthResults parses all the authentication result headers of the
+// envelope. Within each kind of header, results appear in header order,
+// which is newest first, except for ARC results, which are in instance
+// order.
+func parseAuthResults(e *enmime.Envelope) []authResult {
+	var results []authResult
+	for _, value := range e.GetHeaderValues("Authentication-Results") {
+		results = append(results, parseAuthenticationResults(value, "Authentication-Results", 0)...)
+	}
+	var arc []authResult
+	for _, value := range e.GetHeaderValues("ARC-Authentication-Results") {
+		// ARC-Authentication-Results: i=1; authserv-id; ...
+		i, rest, _ := strings.Cut(value, ";")
+		instance := 0
+		if k, v, ok := strings.Cut(strings.TrimSpace(i), "="); ok && strings.EqualFold(strings.TrimSpace(k), "i") {
+			instance, _ = strconv.Atoi(strings.TrimSpace(v))
+		}
+		arc = append(arc, parseAuthenticationResults(rest, "ARC-Authentication-Results", instance)...)
+	}
+	sort.SliceStable(arc, func(i, j int) bool {
+		return arc[i].instance < arc[j].instance
+	})
+	results = append(results, arc...)
+	for _, value := range e.GetHeaderValues("Received-SPF") {
+		if r, ok := parseReceivedSPF(value); ok {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// parseAuthenticationResults parses the value of an RFC 8601
+// Authentication-Results header.
+func parseAuthenticationResults(value, header string, instance int) []authResult {
+	segments := splitAuthSegments(stripAuthComments(value))
+	if len(segments) == 0 {
+		return nil
+	}
+	// The first segment is the authserv-id, optionally followed by a
+	// version number. Some servers, notably Exchange Online, omit it and
+	// start with the first result.
+	fields := splitAuthFields(segments[0])
+	if len(fields) == 0 {
+		return nil
+	}
+	var authservID string
+	if !strings.ContainsRune(fields[0], '=') {
+		authservID = strings.ToLower(fields[0])
+		segments = segments[1:]
+	}
+	var results []authResult
+	for _, segment := range segments {
+		fields = splitAuthFields(segment)
+		if len(fields) == 0 {
+			continue
+		}
+		methodSpec, result, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			continue
+		}
+		method, _, _ := strings.Cut(methodSpec, "/")
+		method = strings.ToLower(method)
+		if method == "none" {
+			continue
+		}
+		r := authResult{
+			header:     header,
+			authservID: authservID,
+			instance:   instance,
+			method:     method,
+			result:     strings.ToLower(unquoteAuthValue(result)),
+			props:      make(map[string]string),
+		}
+		for _, field := range fields[1:] {
+			k, v, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			k = strings.ToLower(k)
+			v = unquoteAuthValue(v)
+			if k == "reason" {
+				r.reason = v
+			} else {
+				r.props[k] = v
+			}
+		}
+		r.domain, r.selector = authDomainAndSelector(method, r.props)
+		results = append(results, r)
+	}
+	return results
+}
+
+// parseReceivedSPF parses the value of an RFC 7208 Received-SPF header.
+// The receiver is used as the authserv-id.
+func parseReceivedSPF(value string) (authResult, bool) {
+	value = stripAuthComments(value)
+	result, rest, _ := strings.Cut(strings.TrimSpace(value), " ")
+	if result == "" {
+		return authResult{}, false
+	}
+	r := authResult{
+		header: "Received-SPF",
+		method: "spf",
+		result: strings.ToLower(result),
+		props:  make(map[string]string),
+	}
+	for _, segment := range splitAuthSegments(rest) {
+		for _, field := range splitAuthFields(segment) {
+			if k, v, ok := strings.Cut(field, "="); ok {
+				r.props[strings.ToLower(k)] = unquoteAuthValue(v)
+			}
+		}
+	}
+	r.authservID = strings.ToLower(r.props["receiver"])
+	if from := r.props["envelope-from"]; from != "" {
+		r.domain = domainOf(from)
+	} else {
+		r.domain = strings.ToLower(r.props["helo"])
+	}
+	return r, true
+}
+
+func authDomainAndSelector(method string, props map[string]string) (domain, selector string) {
+	switch method {
+	case "dkim":
+		if d := props["header.d"]; d != "" {
+			domain = strings.ToLower(d)
+		} else if i := props["header.i"]; i != "" {
+			domain = domainOf(i)
+		}
+		selector = props["header.s"]
+	case "spf":
+		if from := props["smtp.mailfrom"]; from != "" {
+			domain = domainOf(from)
+		} else {
+			domain = strings.ToLower(props["smtp.helo"])
+		}
+	case "dmarc":
+		domain = strings.ToLower(props["header.from"])
+	case "arc":
+		domain = strings.ToLower(props["header.d"])
+		selector = props["header.s"]
+	}
+	return
+}
+
+func domainOf(address string) string {
+	if i := strings.LastIndexByte(address, '@'); i >= 0 {
+		address = address[i+1:]
+	}
+	return strings.ToLower(strings.Trim(address, "<> "))
+}
+
+// stripAuthComments removes parenthesized comments, which may nest,
+// outside quoted strings.
+func stripAuthComments(value string) string {
+	var b strings.Builder
+	depth := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && (quoted || depth > 0) && i+1 < len(value):
+			if depth == 0 {
+				b.WriteByte(c)
+				b.WriteByte(value[i+1])
+			}
+			i++
+			continue
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+			continue
+		case c == ')' && !quoted && depth > 0:
+			depth--
+			b.WriteByte(' ')
+			continue
+		}
+		if depth == 0 {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// splitAuthSegments splits on semicolons outside quoted strings.
+func splitAuthSegments(value string) []string {
+	var segments []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				segments = append(segments, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	segments = append(segments, value[start:])
+	return segments
+}
+
+// splitAuthFields splits on whitespace outside quoted strings, after
+// removing any whitespace around equals signs.
+func splitAuthFields(segment string) []string {
+	var fields []string
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(segment):
+			b.WriteByte(c)
+			b.WriteByte(segment[i+1])
+			i++
+			continue
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			rest := strings.TrimLeft(segment[i:], " \t\r\n")
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "=") && !strings.HasPrefix(rest, "=") {
+				fields = append(fields, b.String())
+				b.Reset()
+			}
+			continue
+		}
+		b.WriteByte(c)
+	}
+	if b.Len() > 0 {
+		fields = append(fields, b.String())
+	}
+	return fields
+}
+
+func unquoteAuthValue(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if s, err := strconv.Unquote(value); err == nil {
+			return s
+		}
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+type jsAuth struct {
+	all []authResult
+
+	results     goja.Value // []auth result
+	dkim        goja.Value // []auth result
+	spf         goja.Value // []auth result
+	dmarc       goja.Value // []auth result
+	arc         goja.Value // []auth result
+	authservIds goja.Value // []string
+}
+
+func marshalAuth(cont *vmContainer, results []authResult) (goja.Value, error) {
+	if cont.authProto == nil {
+		proto, err := jsAuthPrototype(cont)
+		if err != nil {
+			return nil, err
+		}
+		cont.authProto = proto
+	}
+	a := &jsAuth{
+		all: results,
+	}
+	o := cont.vm.ToValue(a).ToObject(cont.vm)
+	err := o.SetPrototype(cont.authProto)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func jsAuthPrototype(cont *vmContainer) (*goja.Object, error) {
+	proto := cont.vm.NewObject()
+	err := defineCachedProperty(cont.vm, proto, "results", func(a *jsAuth) (goja.Value, error) {
+		return marshalAuthResults(cont, a.all, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, method := range []string{"dkim", "spf", "dmarc", "arc"} {
+		method := method
+		err = defineCachedProperty(cont.vm, proto, method, func(a *jsAuth) (goja.Value, error) {
+			return marshalAuthResults(cont, a.all, method)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = defineCachedProperty(cont.vm, proto, "authservIds", func(a *jsAuth) (goja.Value, error) {
+		ids := make([]string, 0)
+		seen := make(map[string]bool)
+		for i := range a.all {
+			if id := a.all[i].authservID; id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		return cont.vm.ToValue(ids), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = proto.Set("trusted", cont.vm.ToValue(func(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+		return jsAuthTrusted(cont, call)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	err = proto.Set("pass", cont.vm.ToValue(jsAuthPass))
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
+
+// jsAuthTrusted returns a new auth object containing only the results
+// whose authserv-id is one of the arguments. Since anyone can add an
+// authentication result header to a message, rules should only rely on
+// results added by their own trusted border servers.
+func jsAuthTrusted(cont *vmContainer, call goja.FunctionCall) goja.Value {
+	vm := cont.vm
+	this_ := call.This.Export()
+	this, ok := this_.(*jsAuth)
+	if !ok {
+		throwJSException(vm, errUnexpectedThisType(&jsAuth{}, this_))
+	}
+	ids := make(map[string]bool, len(call.Arguments))
+	for i, arg := range call.Arguments {
+		id_ := arg.Export()
+		id, ok := id_.(string)
+		if !ok {
+			throwJSException(vm, errUnexpectedArgType(i, "", id_))
+		}
+		ids[strings.ToLower(id)] = true
+	}
+	trusted := make([]authResult, 0, len(this.all))
+	for i := range this.all {
+		if ids[this.all[i].authservID] {
+			trusted = append(trusted, this.all[i])
+		}
+	}
+	o, err := marshalAuth(cont, trusted)
+	if err != nil {
+		throwJSException(vm, err)
+	}
+	return o
+}
+
+// jsAuthPass reports whether there is a passing result for the method
+// given as the first argument. If a domain is given as the second
+// argument, the result must also be for that domain.
+func jsAuthPass(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	if len(call.Arguments) < 1 || len(call.Arguments) > 2 {
+		throwJSException(vm, "reeed: pass() requires one or two arguments")
+	}
+	method_ := call.Arguments[0].Export()
+	method, ok := method_.(string)
+	if !ok {
+		throwJSException(vm, errUnexpectedArgType(0, "", method_))
+	}
+	var domain string
+	if len(call.Arguments) == 2 && !goja.IsUndefined(call.Arguments[1]) && !goja.IsNull(call.Arguments[1]) {
+		domain_ := call.Arguments[1].Export()
+		if domain, ok = domain_.(string); !ok {
+			throwJSException(vm, errUnexpectedArgType(1, "", domain_))
+		}
+	}
+	this_ := call.This.Export()
+	this, ok := this_.(*jsAuth)
+	if !ok {
+		throwJSException(vm, errUnexpectedThisType(&jsAuth{}, this_))
+	}
+	for i := range this.all {
+		r := &this.all[i]
+		if strings.EqualFold(r.method, method) && r.result == "pass" && (domain == "" || strings.EqualFold(r.domain, domain)) {
+			return vm.ToValue(true)
+		}
+	}
+	return vm.ToValue(false)
+}
+
+func marshalAuthResults(cont *vmContainer, results []authResult, method string) (goja.Value, error) {
+	if cont.authResultProto == nil {
+		proto, err := jsAuthResultPrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.authResultProto = proto
+	}
+	a := make([]goja.Value, 0, len(results))
+	for i := range results {
+		if method != "" && results[i].method != method {
+			continue
+		}
+		r := &jsAuthResult{
+			r: &results[i],
+		}
+		o := cont.vm.ToValue(r).ToObject(cont.vm)
+		err := o.SetPrototype(cont.authResultProto)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, o)
+	}
+	return cont.vm.ToValue(a), nil
+}
+
+type jsAuthResult struct {
+	r *authResult
+
+	header     goja.Value // string
+	authservId goja.Value // string
+	instance   goja.Value // number
+	method     goja.Value // string
+	result     goja.Value // string
+	reason     goja.Value // string
+	properties goja.Value // object
+	domain     goja.Value // string
+	selector   goja.Value // string
+}
+
+func jsAuthResultPrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	stringProps := []struct {
+		propName string
+		get      func(*authResult) string
+	}{
+		{"header", func(r *authResult) string { return r.header }},
+		{"authservId", func(r *authResult) string { return r.authservID }},
+		{"method", func(r *authResult) string { return r.method }},
+		{"result", func(r *authResult) string { return r.result }},
+		{"reason", func(r *authResult) string { return r.reason }},
+		{"domain", func(r *authResult) string { return r.domain }},
+		{"selector", func(r *authResult) string { return r.selector }},
+	}
+	for _, prop := range stringProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(r *jsAuthResult) (goja.Value, error) {
+			if value := get(r.r); value != "" {
+				return vm.ToValue(value), nil
+			}
+			return goja.Null(), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := defineCachedProperty(vm, proto, "instance", func(r *jsAuthResult) (goja.Value, error) {
+		if r.r.instance == 0 {
+			return goja.Null(), nil
+		}
+		return vm.ToValue(r.r.instance), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "properties", func(r *jsAuthResult) (goja.Value, error) {
+		props := vm.NewObject()
+		for k, v := range r.r.props {
+			err := props.Set(k, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return props, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
@@ -0,0 +1,323 @@
+package rule
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jhillyerd/enmime"
+)
+
+func TestParseAuthenticationResults(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		instance int
+		expected []authResult
+	}{
+		{
+			name: "gmail",
+			value: "mx.google.com;\r\n" +
+				"       dkim=pass header.i=@example.com header.s=20210112 header.b=Kq3bF4xz;\r\n" +
+				"       spf=pass (google.com: domain of bounce@example.com designates 209.85.220.41 as permitted sender) smtp.mailfrom=bounce@example.com;\r\n" +
+				"       dmarc=pass (p=NONE sp=NONE dis=NONE) header.from=example.com",
+			expected: []authResult{
+				{
+					authservID: "mx.google.com",
+					method:     "dkim",
+					result:     "pass",
+					props:      map[string]string{"header.i": "@example.com", "header.s": "20210112", "header.b": "Kq3bF4xz"},
+					domain:     "example.com",
+					selector:   "20210112",
+				},
+				{
+					authservID: "mx.google.com",
+					method:     "spf",
+					result:     "pass",
+					props:      map[string]string{"smtp.mailfrom": "bounce@example.com"},
+					domain:     "example.com",
+				},
+				{
+					authservID: "mx.google.com",
+					method:     "dmarc",
+					result:     "pass",
+					props:      map[string]string{"header.from": "example.com"},
+					domain:     "example.com",
+				},
+			},
+		},
+		{
+			name: "exchange online without authserv-id",
+			value: "spf=pass (sender IP is 40.107.22.53)\r\n" +
+				" smtp.mailfrom=contoso.com; dkim=pass (signature was verified)\r\n" +
+				" header.d=contoso.com;dmarc=pass action=none\r\n" +
+				" header.from=contoso.com;compauth=pass reason=100",
+			expected: []authResult{
+				{
+					method: "spf",
+					result: "pass",
+					props:  map[string]string{"smtp.mailfrom": "contoso.com"},
+					domain: "contoso.com",
+				},
+				{
+					method: "dkim",
+					result: "pass",
+					props:  map[string]string{"header.d": "contoso.com"},
+					domain: "contoso.com",
+				},
+				{
+					method: "dmarc",
+					result: "pass",
+					props:  map[string]string{"action": "none", "header.from": "contoso.com"},
+					domain: "contoso.com",
+				},
+				{
+					method: "compauth",
+					result: "pass",
+					reason: "100",
+					props:  map[string]string{},
+				},
+			},
+		},
+		{
+			name:  "version and quoted reason",
+			value: `example.org 1; dkim=fail reason="signature verification failed" header.d=example.net header.s=sel`,
+			expected: []authResult{
+				{
+					authservID: "example.org",
+					method:     "dkim",
+					result:     "fail",
+					reason:     "signature verification failed",
+					props:      map[string]string{"header.d": "example.net", "header.s": "sel"},
+					domain:     "example.net",
+					selector:   "sel",
+				},
+			},
+		},
+		{
+			name:     "arc instance",
+			value:    " mx.example.com; arc=pass (as.1.example.net=pass) header.d=example.net header.s=arc-2016",
+			instance: 2,
+			expected: []authResult{
+				{
+					authservID: "mx.example.com",
+					instance:   2,
+					method:     "arc",
+					result:     "pass",
+					props:      map[string]string{"header.d": "example.net", "header.s": "arc-2016"},
+					domain:     "example.net",
+					selector:   "arc-2016",
+				},
+			},
+		},
+		{
+			name:  "no results",
+			value: "example.org; none",
+		},
+		{
+			name:  "whitespace around equals",
+			value: "MX.Example.COM; SPF = SoftFail smtp.mailfrom = a@Example.NET",
+			expected: []authResult{
+				{
+					authservID: "mx.example.com",
+					method:     "spf",
+					result:     "softfail",
+					props:      map[string]string{"smtp.mailfrom": "a@Example.NET"},
+					domain:     "example.net",
+				},
+			},
+		},
+		{
+			name:  "nested comment and quoted semicolon",
+			value: `mx (outer (inner) comment); dkim=pass (good; signature) header.d=example.com header.b="ab;cd"`,
+			expected: []authResult{
+				{
+					authservID: "mx",
+					method:     "dkim",
+					result:     "pass",
+					props:      map[string]string{"header.d": "example.com", "header.b": "ab;cd"},
+					domain:     "example.com",
+				},
+			},
+		},
+		{
+			name:  "unbalanced parenthesis",
+			value: "mx.example.com; spf=pass (unterminated smtp.mailfrom=a@example.com; dkim=pass header.d=example.com",
+			expected: []authResult{
+				{
+					authservID: "mx.example.com",
+					method:     "spf",
+					result:     "pass",
+					props:      map[string]string{},
+				},
+			},
+		},
+		{
+			name:  "trailing backslash",
+			value: `mx.example.com; dkim=fail reason="bad\`,
+			expected: []authResult{
+				{
+					authservID: "mx.example.com",
+					method:     "dkim",
+					result:     "fail",
+					reason:     `"bad\`,
+					props:      map[string]string{},
+				},
+			},
+		},
+		{
+			name:  "result without value",
+			value: "mx.example.com; dkim; spf=none",
+			expected: []authResult{
+				{
+					authservID: "mx.example.com",
+					method:     "spf",
+					result:     "none",
+					props:      map[string]string{},
+				},
+			},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			for i := range testCase.expected {
+				testCase.expected[i].header = "Authentication-Results"
+			}
+			actual := parseAuthenticationResults(testCase.value, "Authentication-Results", testCase.instance)
+			assertAuthResults(t, testCase.expected, actual)
+		})
+	}
+}
+
+func TestParseReceivedSPF(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected *authResult
+	}{
+		{
+			name: "rfc 7208 example",
+			value: "Pass (mybox.example.org: domain of myname@example.com designates 192.0.2.1 as permitted sender)\r\n" +
+				"      receiver=mybox.example.org; client-ip=192.0.2.1;\r\n" +
+				"      envelope-from=\"myname@example.com\"; helo=foo.example.com;",
+			expected: &authResult{
+				authservID: "mybox.example.org",
+				method:     "spf",
+				result:     "pass",
+				props: map[string]string{
+					"receiver":      "mybox.example.org",
+					"client-ip":     "192.0.2.1",
+					"envelope-from": "myname@example.com",
+					"helo":          "foo.example.com",
+				},
+				domain: "example.com",
+			},
+		},
+		{
+			name:  "gmail",
+			value: "pass (google.com: domain of bounce@example.com designates 209.85.220.41 as permitted sender) client-ip=209.85.220.41;",
+			expected: &authResult{
+				method: "spf",
+				result: "pass",
+				props:  map[string]string{"client-ip": "209.85.220.41"},
+			},
+		},
+		{
+			name:  "helo only",
+			value: "None (mx.example.org: no SPF record) receiver=mx.example.org; helo=MAIL.Example.NET",
+			expected: &authResult{
+				authservID: "mx.example.org",
+				method:     "spf",
+				result:     "none",
+				props:      map[string]string{"receiver": "mx.example.org", "helo": "MAIL.Example.NET"},
+				domain:     "mail.example.net",
+			},
+		},
+		{
+			name:  "unbalanced parenthesis",
+			value: "softfail (mx.example.org: transitioning domain receiver=mx.example.org",
+			expected: &authResult{
+				method: "spf",
+				result: "softfail",
+				props:  map[string]string{},
+			},
+		},
+		{
+			name:  "comment only",
+			value: "(no result)",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual, ok := parseReceivedSPF(testCase.value)
+			if testCase.expected == nil {
+				if ok {
+					t.Fatalf("expected no result, got %+v", actual)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected a result, got none")
+			}
+			testCase.expected.header = "Received-SPF"
+			assertAuthResults(t, []authResult{*testCase.expected}, []authResult{actual})
+		})
+	}
+}
+
+func TestParseAuthResults(t *testing.T) {
+	text := "ARC-Authentication-Results: i=2; mx.example.com; arc=pass header.d=example.net\r\n" +
+		"Authentication-Results: mx.example.com; spf=fail smtp.mailfrom=a@example.org\r\n" +
+		"ARC-Authentication-Results: i=1; relay.example.net; dkim=pass header.d=example.org\r\n" +
+		"Received-SPF: fail receiver=mx.example.com; envelope-from=a@example.org\r\n" +
+		"Authentication-Results: relay.example.net; dkim=pass header.d=example.org\r\n" +
+		"Subject: test\r\n" +
+		"\r\n" +
+		"body\r\n"
+	e, err := enmime.ReadEnvelope(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := parseAuthResults(e)
+
+	type summary struct {
+		header, authservID string
+		instance           int
+		method, result     string
+	}
+	expected := []summary{
+		{"Authentication-Results", "mx.example.com", 0, "spf", "fail"},
+		{"Authentication-Results", "relay.example.net", 0, "dkim", "pass"},
+		{"ARC-Authentication-Results", "relay.example.net", 1, "dkim", "pass"},
+		{"ARC-Authentication-Results", "mx.example.com", 2, "arc", "pass"},
+		{"Received-SPF", "mx.example.com", 0, "spf", "fail"},
+	}
+	actual := make([]summary, len(results))
+	for i, r := range results {
+		actual[i] = summary{r.header, r.authservID, r.instance, r.method, r.result}
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func assertAuthResults(t *testing.T, expected, actual []authResult) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("expected %d results, got %d: %+v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(expected[i], actual[i]) {
+			t.Errorf("result %d: expected %+v, got %+v", i, expected[i], actual[i])
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package rule
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/jaytaylor/html2text"
+	"golang.org/x/net/html"
+)
+
+var (
+	// replyHeaderRegexp matches the attribution line mail clients put
+	// above a quoted reply, e.g. "On Mon, 1 May 2023, Bob wrote:", in a
+	// few common languages. The line may be wrapped. In German and Dutch,
+	// the sender may follow the verb.
+	replyHeaderRegexp = regexp.MustCompile(`(?is)^(?:On\s.{1,300}\swrote|Le\s.{1,300}\sa\s+écrit|Am\s.{1,300}\sschrieb(?:\s.{1,300})?|El\s.{1,300}\sescribió|Il\s.{1,300}\sha\s+scritto|Op\s.{1,300}\sschreef(?:\s.{1,300})?)\s*:$`)
+	// outlookSeparatorRegexp matches the separators Outlook and other
+	// clients put above quoted or forwarded messages.
+	outlookSeparatorRegexp = regexp.MustCompile(`(?i)^(?:-{2,}\s*Original Message\s*-{2,}|_{10,}|-{5,}\s*Reply message\s*-{5,})$`)
+	// outlookHeaderRegexp matches the first line of the header block
+	// Outlook puts above a quoted message.
+	outlookHeaderRegexp = regexp.MustCompile(`(?i)^\*?(?:From|De|Von|Van)\s*:\*?\s`)
+	// outlookHeaderNextRegexp matches the lines following the first line
+	// of an Outlook header block.
+	outlookHeaderNextRegexp = regexp.MustCompile(`(?i)^\*?(?:Sent|Date|To|Subject|Cc|Envoyé|Gesendet|Verzonden)\s*:`)
+	// mobileFooterRegexp matches the footers mobile and webmail clients
+	// add to new messages.
+	mobileFooterRegexp = regexp.MustCompile(`(?i)^(?:Sent from my \S.*|Sent from (?:Mail|Outlook|Yahoo Mail|Gmail|ProtonMail|Proton Mail)\b.*|Sent via \S.*|Get Outlook for (?:iOS|Android)|Envoyé de mon \S.*|Von meinem \S.* gesendet)$`)
+)
+
+// newContent returns the part of a plain text message body which was
+// written by the sender, by removing quoted lines, everything after a
+// reply attribution line or a separator introducing a quoted message,
+// and the sender's signature.
+func newContent(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	kept := make([]string, 0, len(lines))
+scan:
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, ">"):
+			continue
+		case line == "--":
+			break scan
+		case mobileFooterRegexp.MatchString(trimmed):
+			break scan
+		case outlookSeparatorRegexp.MatchString(trimmed):
+			break scan
+		case outlookHeaderRegexp.MatchString(trimmed) && i+1 < len(lines) && outlookHeaderNextRegexp.MatchString(strings.TrimSpace(lines[i+1])):
+			break scan
+		case isReplyHeader(lines, i):
+			break scan
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// isReplyHeader reports whether the reply attribution line starts at
+// lines[i], possibly wrapped over the next line.
+func isReplyHeader(lines []string, i int) bool {
+	trimmed := strings.TrimSpace(lines[i])
+	if trimmed == "" {
+		return false
+	}
+	if replyHeaderRegexp.MatchString(trimmed) {
+		return true
+	}
+	if i+1 < len(lines) {
+		return replyHeaderRegexp.MatchString(trimmed + " " + strings.TrimSpace(lines[i+1]))
+	}
+	return false
+}
+
+// htmlNewContent renders an HTML message body as plain text and returns
+// the part which was written by the sender. The quote and signature
+// containers used by common mail clients are removed before rendering.
+func htmlNewContent(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	removeQuoteNodes(doc)
+	text, err := html2text.FromHTMLNode(doc, html2text.Options{OmitLinks: true})
+	if err != nil {
+		return "", err
+	}
+	return newContent(text), nil
+}
+
+// removeQuoteNodes removes quoted replies and signatures from an HTML
+// document.
+func removeQuoteNodes(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && isQuoteStartNode(c) {
+			// Outlook doesn't wrap the quoted message, so everything
+			// after the marker is quoted.
+			for ; c != nil; c = next {
+				next = c.NextSibling
+				n.RemoveChild(c)
+			}
+			break
+		} else if c.Type == html.ElementNode && isQuoteNode(c) {
+			n.RemoveChild(c)
+		} else {
+			removeQuoteNodes(c)
+		}
+		c = next
+	}
+}
+
+// isQuoteStartNode reports whether a node marks the start of a quoted
+// message which continues to the end of its parent.
+func isQuoteStartNode(n *html.Node) bool {
+	switch htmlAttr(n, "id") {
+	case "appendonsend", "divRplyFwdMsg":
+		return true
+	}
+	return false
+}
+
+func isQuoteNode(n *html.Node) bool {
+	if n.Data == "blockquote" {
+		return true
+	}
+	switch htmlAttr(n, "id") {
+	case "mail-editor-reference-message-container", "Signature":
+		return true
+	}
+	for _, class := range strings.Fields(htmlAttr(n, "class")) {
+		switch class {
+		case "gmail_quote", "gmail_signature", "gmail_extra", "moz-cite-prefix", "moz-signature", "yahoo_quoted", "protonmail_quote", "protonmail_signature_block":
+			return true
+		}
+	}
+	return false
+}
+
+type jsBody struct {
+	msg *jsMessage
+
+	text goja.Value // string
+	html goja.Value // string
+}
+
+func marshalBody(cont *vmContainer, msg *jsMessage) (goja.Value, error) {
+	if cont.bodyProto == nil {
+		proto, err := jsBodyPrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.bodyProto = proto
+	}
+	b := &jsBody{
+		msg: msg,
+	}
+	o := cont.vm.ToValue(b).ToObject(cont.vm)
+	err := o.SetPrototype(cont.bodyProto)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func jsBodyPrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	err := defineCachedProperty(vm, proto, "text", func(b *jsBody) (goja.Value, error) {
+		return vm.ToValue(newContent(b.msg.msg.Envelope.Text)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "html", func(b *jsBody) (goja.Value, error) {
+		if b.msg.msg.Envelope.HTML == "" {
+			return goja.Null(), nil
+		}
+		text, err := htmlNewContent(b.msg.msg.Envelope.HTML)
+		if err != nil {
+			return goja.Null(), nil
+		}
+		return vm.ToValue(text), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = proto.Set("strip", vm.ToValue(func(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+		if len(call.Arguments) != 1 {
+			throwJSException(vm, "reeed: strip() requires exactly 1 argument")
+		}
+		s, ok := call.Arguments[0].Export().(string)
+		if !ok {
+			throwJSException(vm, errUnexpectedArgType(0, "", call.Arguments[0].Export()))
+		}
+		return vm.ToValue(newContent(s))
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
@@ -0,0 +1,148 @@
+package rule
+
+import "testing"
+
+func TestNewContent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{
+			name: "gmail wrapped attribution",
+			text: "Sounds good, see you then.\r\n\r\n" +
+				"On Mon, May 1, 2023 at 9:14 AM Bob Smith <bob@example.com>\r\n" +
+				"wrote:\r\n\r\n" +
+				"> Are we still on for lunch?\r\n" +
+				">\r\n",
+			expected: "Sounds good, see you then.",
+		},
+		{
+			name: "outlook header block",
+			text: "Thanks, I'll review it today.\r\n\r\nJane\r\n\r\n" +
+				"From: Bob Smith <bob@example.com>\r\n" +
+				"Sent: Monday, May 1, 2023 9:14 AM\r\n" +
+				"To: Jane Doe <jane@example.com>\r\n" +
+				"Subject: Report\r\n\r\n" +
+				"Please review the attached report.\r\n",
+			expected: "Thanks, I'll review it today.\n\nJane",
+		},
+		{
+			name:     "original message separator",
+			text:     "FYI\n\n-----Original Message-----\nFrom: Bob\nPlease forward this.\n",
+			expected: "FYI",
+		},
+		{
+			name:     "signature",
+			text:     "Hi all,\n\nThe build is green again.\n\n-- \nAlice Example\nBuild Engineer\n",
+			expected: "Hi all,\n\nThe build is green again.",
+		},
+		{
+			name:     "mobile footer",
+			text:     "Running late, start without me.\n\nSent from my iPhone\n",
+			expected: "Running late, start without me.",
+		},
+		{
+			name:     "interleaved quotes",
+			text:     "> Question one?\nAnswer one.\n  > Question two?\nAnswer two.",
+			expected: "Answer one.\nAnswer two.",
+		},
+		{
+			name:     "german attribution",
+			text:     "Danke!\n\nAm 01.05.2023 um 09:14 schrieb Bob <bob@example.com>:\n> Hallo\n",
+			expected: "Danke!",
+		},
+		{
+			name:     "dutch attribution",
+			text:     "Prima.\n\nOp ma 1 mei 2023 om 09:14 schreef Bob <bob@example.com>:\n> Hallo\n",
+			expected: "Prima.",
+		},
+		{
+			name:     "french attribution",
+			text:     "Merci.\n\nLe lun. 1 mai 2023 à 09:14, Bob <bob@example.com> a écrit :\n> Bonjour\n",
+			expected: "Merci.",
+		},
+		{
+			name:     "on without attribution",
+			text:     "On Monday we ship.\nThat is all.",
+			expected: "On Monday we ship.\nThat is all.",
+		},
+		{
+			name:     "from line without header block",
+			text:     "From: the team\nWelcome aboard!",
+			expected: "From: the team\nWelcome aboard!",
+		},
+		{
+			name: "only quoted",
+			text: "> all of this\n> is quoted",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual := newContent(testCase.text)
+
+			if actual != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestHTMLNewContent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{
+			name: "gmail quote",
+			html: `<div dir="ltr">Sounds good.<br></div><br>` +
+				`<div class="gmail_quote"><div dir="ltr" class="gmail_attr">On Mon, May 1, 2023 at 9:14 AM Bob &lt;bob@example.com&gt; wrote:<br></div>` +
+				`<blockquote class="gmail_quote" style="margin:0px 0px 0px 0.8ex">Lunch?</blockquote></div>`,
+			expected: "Sounds good.",
+		},
+		{
+			name: "outlook reply",
+			html: `<html><body><div>Will do.</div><div id="appendonsend"></div>` +
+				`<hr style="display:inline-block;width:98%">` +
+				`<div id="divRplyFwdMsg" dir="ltr"><b>From:</b> Bob Smith<br><b>Sent:</b> Monday, May 1, 2023 9:14 AM<br><b>Subject:</b> Report</div>` +
+				`<div>Please review the report.</div></body></html>`,
+			expected: "Will do.",
+		},
+		{
+			name:     "gmail signature",
+			html:     `<p>Approved.</p><div class="gmail_signature">Alice<br>CTO</div>`,
+			expected: "Approved.",
+		},
+		{
+			name:     "thunderbird",
+			html:     `<p>Agreed.</p><div class="moz-cite-prefix">On 5/1/23 9:14 AM, Bob wrote:<br></div><blockquote type="cite">Ship it?</blockquote>`,
+			expected: "Agreed.",
+		},
+		{
+			name:     "unclosed tags",
+			html:     `<p>Unclosed <b>bold`,
+			expected: "Unclosed *bold*",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual, err := htmlNewContent(testCase.html)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
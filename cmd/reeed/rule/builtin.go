@@ -0,0 +1,113 @@
+package rule
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/gogama/reee-evolution/daemon"
+	"github.com/gogama/reee-evolution/log"
+)
+
+func init() {
+	Register("header-regex", newHeaderRegexRule)
+	Register("sender-in-file", newSenderInFileRule)
+	Register("has-attachment-type", newHasAttachmentTypeRule)
+}
+
+type nativeRule struct {
+	name string
+	eval func(msg *daemon.Message) bool
+}
+
+func (r *nativeRule) String() string {
+	return r.name
+}
+
+func (r *nativeRule) Eval(_ context.Context, _ log.Printer, msg *daemon.Message, _ daemon.Tagger) (match bool, err error) {
+	return r.eval(msg), nil
+}
+
+// newHeaderRegexRule matches if any value of the header named by the
+// "header" parameter matches the "regex" parameter.
+func newHeaderRegexRule(name string, params Params) (daemon.Rule, error) {
+	header, err := params.String("header")
+	if err != nil {
+		return nil, err
+	}
+	expr, err := params.String("regex")
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &nativeRule{
+		name: name,
+		eval: func(msg *daemon.Message) bool {
+			for _, value := range msg.Envelope.GetHeaderValues(header) {
+				if re.MatchString(value) {
+					return true
+				}
+			}
+			return false
+		},
+	}, nil
+}
+
+// newSenderInFileRule matches if a From address appears in the file
+// named by the "path" parameter. The file has the same format as a list
+// file exposed as reee.lists.
+func newSenderInFileRule(name string, params Params) (daemon.Rule, error) {
+	path, err := params.Path("path")
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := loadAddressList(name, path)
+	if err != nil {
+		return nil, err
+	}
+	return &nativeRule{
+		name: name,
+		eval: func(msg *daemon.Message) bool {
+			list, err := msg.Envelope.AddressList("From")
+			if err != nil {
+				return false
+			}
+			for _, addr := range list {
+				if addresses.contains(addr.Address) {
+					return true
+				}
+			}
+			return false
+		},
+	}, nil
+}
+
+// newHasAttachmentTypeRule matches if the message has an attachment
+// whose content type is one of the "types" parameter. A type of the
+// form "image/*" matches any subtype.
+func newHasAttachmentTypeRule(name string, params Params) (daemon.Rule, error) {
+	types, err := params.Strings("types")
+	if err != nil {
+		return nil, err
+	}
+	for i := range types {
+		types[i] = strings.ToLower(types[i])
+	}
+	return &nativeRule{
+		name: name,
+		eval: func(msg *daemon.Message) bool {
+			for _, part := range msg.Envelope.Attachments {
+				contentType := strings.ToLower(part.ContentType)
+				for _, t := range types {
+					if t == contentType || strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, t[:len(t)-1]) {
+						return true
+					}
+				}
+			}
+			return false
+		},
+	}, nil
+}
@@ -0,0 +1,292 @@
+package rule
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/dop251/goja"
+	"github.com/gogama/reee-evolution/log"
+	"github.com/teambition/rrule-go"
+)
+
+const (
+	// busyHorizon bounds how far into the future recurring events are
+	// expanded when looking for conflicts.
+	busyHorizon = 366 * 24 * time.Hour
+)
+
+// BusyCalendar is the set of busy events in one or more local
+// iCalendar files, which are reloaded when they change. Events which
+// are cancelled or marked TRANSP:TRANSPARENT are not busy.
+type BusyCalendar struct {
+	r *reloader[[]*busyEvent]
+}
+
+// NewBusyCalendar loads the busy events from the given iCalendar files
+// and directories. Directories are searched recursively for files
+// having the extension .ics.
+func NewBusyCalendar(logger log.Printer, paths []string) (*BusyCalendar, error) {
+	r, err := newReloader("calendars", paths, []string{".ics"}, logger, loadBusyEvents)
+	if err != nil {
+		return nil, fmt.Errorf("reeed: failed to load calendars: %w", err)
+	}
+	return &BusyCalendar{r: r}, nil
+}
+
+type busyEvent struct {
+	calendar   *ics.Calendar
+	event      *ics.VEvent
+	uid        string
+	start      time.Time
+	duration   time.Duration
+	allDay     bool
+	recurrence *rrule.Set // nil if the event doesn't recur
+}
+
+// busyOccurrence is one occurrence of a busy event.
+type busyOccurrence struct {
+	*busyEvent
+	start, end time.Time
+}
+
+func loadBusyEvents(files []string) ([]*busyEvent, error) {
+	var events []*busyEvent
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		calendar, err := ics.ParseCalendar(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		events = append(events, busyEvents(calendar)...)
+	}
+	return events, nil
+}
+
+// busyEvents returns the busy events in a calendar. Recurrence
+// overrides, which are events having a RECURRENCE-ID, replace the
+// matching occurrence of the recurring event having the same UID.
+func busyEvents(calendar *ics.Calendar) []*busyEvent {
+	var events []*busyEvent
+	overrides := make(map[string][]time.Time)
+	for _, event := range calendar.Events() {
+		var uid string
+		if p := event.GetProperty(ics.ComponentPropertyUniqueId); p != nil {
+			uid = p.Value
+		}
+		if p := event.GetProperty(ics.ComponentProperty(ics.PropertyRecurrenceId)); p != nil {
+			if t, _, err := calendarTime(calendar, p); err == nil {
+				overrides[uid] = append(overrides[uid], t)
+			}
+		}
+		if !isBusyEvent(event) {
+			continue
+		}
+		start, end, allDay, err := eventTimes(calendar, event)
+		if err != nil {
+			continue
+		}
+		e := &busyEvent{
+			calendar: calendar,
+			event:    event,
+			uid:      uid,
+			start:    start,
+			duration: end.Sub(start),
+			allDay:   allDay,
+		}
+		if event.GetProperty(ics.ComponentProperty(ics.PropertyRecurrenceId)) == nil {
+			e.recurrence = eventRecurrence(calendar, event, start)
+		}
+		events = append(events, e)
+	}
+	for _, e := range events {
+		if e.recurrence == nil {
+			continue
+		}
+		for _, t := range overrides[e.uid] {
+			e.recurrence.ExDate(t)
+		}
+	}
+	return events
+}
+
+func isBusyEvent(event *ics.VEvent) bool {
+	if p := event.GetProperty(ics.ComponentPropertyStatus); p != nil && strings.EqualFold(p.Value, "CANCELLED") {
+		return false
+	}
+	if p := event.GetProperty(ics.ComponentPropertyTransp); p != nil && strings.EqualFold(p.Value, "TRANSPARENT") {
+		return false
+	}
+	return true
+}
+
+// eventRecurrence returns the recurrence set of an event having an
+// RRULE or RDATE, or nil if the event doesn't recur or its RRULE is
+// invalid.
+func eventRecurrence(calendar *ics.Calendar, event *ics.VEvent, start time.Time) *rrule.Set {
+	var set rrule.Set
+	var recurs bool
+	for _, p := range event.Properties {
+		switch ics.Property(p.IANAToken) {
+		case ics.PropertyRrule:
+			opt, err := rrule.StrToROptionInLocation(p.Value, start.Location())
+			if err != nil {
+				return nil
+			}
+			opt.Dtstart = start
+			r, err := rrule.NewRRule(*opt)
+			if err != nil {
+				return nil
+			}
+			set.RRule(r)
+			recurs = true
+		case ics.PropertyRdate:
+			for _, t := range calendarTimes(calendar, p) {
+				set.RDate(t)
+				recurs = true
+			}
+		case ics.PropertyExdate:
+			for _, t := range calendarTimes(calendar, p) {
+				set.ExDate(t)
+			}
+		}
+	}
+	if !recurs {
+		return nil
+	}
+	set.DTStart(start)
+	set.RDate(start)
+	return &set
+}
+
+// calendarTimes parses a property having a comma-separated list of
+// times, such as EXDATE. Values which aren't times, such as RDATE
+// periods, are ignored.
+func calendarTimes(calendar *ics.Calendar, prop ics.IANAProperty) []time.Time {
+	var times []time.Time
+	for _, value := range strings.Split(prop.Value, ",") {
+		single := prop
+		single.Value = value
+		if t, _, err := calendarTime(calendar, &single); err == nil {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+// conflicts returns the occurrences of busy events which overlap the
+// interval [start, end), sorted by start time. Events having the given
+// UID are ignored, so an invitation doesn't conflict with an earlier
+// copy of itself. Recurring events are only expanded up to busyHorizon
+// from now.
+func (cal *BusyCalendar) conflicts(start, end time.Time, uid string) []busyOccurrence {
+	var result []busyOccurrence
+	if cal == nil {
+		return result
+	}
+	if !end.After(start) {
+		end = start.Add(time.Second)
+	}
+	horizon := time.Now().Add(busyHorizon)
+	for _, e := range cal.r.get() {
+		if uid != "" && e.uid == uid {
+			continue
+		}
+		if e.recurrence == nil {
+			if overlaps(e.start, e.start.Add(e.duration), start, end) {
+				result = append(result, busyOccurrence{e, e.start, e.start.Add(e.duration)})
+			}
+			continue
+		}
+		// An occurrence overlaps the interval only if it starts less
+		// than one event duration before the interval starts.
+		duration := e.duration
+		if duration < time.Second {
+			duration = time.Second
+		}
+		before := end
+		if before.After(horizon) {
+			before = horizon
+		}
+		for _, t := range e.recurrence.Between(start.Add(-duration), before, true) {
+			if overlaps(t, t.Add(e.duration), start, end) {
+				result = append(result, busyOccurrence{e, t, t.Add(e.duration)})
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].start.Before(result[j].start)
+	})
+	return result
+}
+
+// overlaps reports whether the interval [s1, e1) overlaps [s2, e2). An
+// empty interval overlaps an interval containing its start.
+func overlaps(s1, e1, s2, e2 time.Time) bool {
+	if !e1.After(s1) {
+		e1 = s1.Add(time.Second)
+	}
+	return s1.Before(e2) && s2.Before(e1)
+}
+
+// installCalendarObject makes the busy calendar available to JavaScript
+// as reee.calendar.
+func installCalendarObject(set *GroupSet, cont *vmContainer, reeeObject *goja.Object) error {
+	vm := cont.vm
+	calendarObject := vm.NewObject()
+	err := calendarObject.Set("conflicts", vm.ToValue(func(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+		if len(call.Arguments) != 1 {
+			throwJSException(vm, "reeed: conflicts() requires exactly 1 argument")
+		}
+		e, ok := call.Arguments[0].Export().(*jsCalendarEvent)
+		if !ok {
+			throwJSException(vm, errUnexpectedArgType(0, &jsCalendarEvent{}, call.Arguments[0].Export()))
+		}
+		start, end, _, err := eventTimes(e.calendar, e.event)
+		if err != nil {
+			throwJSException(vm, fmt.Sprintf("reeed: can't find conflicts: %s", err))
+		}
+		var uid string
+		if p := e.event.GetProperty(ics.ComponentPropertyUniqueId); p != nil {
+			uid = p.Value
+		}
+		occurrences := set.Calendar.conflicts(start, end, uid)
+		a := make([]goja.Value, len(occurrences))
+		for i := range occurrences {
+			a[i], err = marshalBusyOccurrence(cont, &occurrences[i])
+			if err != nil {
+				throwJSException(vm, err)
+			}
+		}
+		return vm.ToValue(a)
+	}))
+	if err != nil {
+		return err
+	}
+	return reeeObject.Set("calendar", calendarObject)
+}
+
+// marshalBusyOccurrence marshals an occurrence of a busy event as a
+// calendar event whose start and end are those of the occurrence.
+func marshalBusyOccurrence(cont *vmContainer, o *busyOccurrence) (goja.Value, error) {
+	v, err := marshalCalendarEvent(cont, o.calendar, o.event)
+	if err != nil {
+		return nil, err
+	}
+	e := v.Export().(*jsCalendarEvent)
+	if e.start, err = marshalDate(cont.vm, o.start); err != nil {
+		return nil, err
+	}
+	if e.end, err = marshalDate(cont.vm, o.end); err != nil {
+		return nil, err
+	}
+	e.allDay = cont.vm.ToValue(o.allDay)
+	return v, nil
+}
@@ -3,6 +3,7 @@ package rule
 import (
 	"bytes"
 	"net/mail"
+	"strconv"
 	"strings"
 
 	ics "github.com/arran4/golang-ical"
@@ -10,7 +11,9 @@ import (
 	"github.com/jhillyerd/enmime"
 )
 
-func parseCalendar(parts ...[]*enmime.Part) *ics.Calendar {
+// parseCalendar parses the first text/calendar part. It returns nil and
+// a nil error if there is no calendar part.
+func parseCalendar(parts ...[]*enmime.Part) (*ics.Calendar, error) {
 	for i := range parts {
 		for j := range parts[i] {
 			part := parts[i][j]
@@ -20,15 +23,26 @@ func parseCalendar(parts ...[]*enmime.Part) *ics.Calendar {
 			reader := bytes.NewReader(part.Content)
 			calendar, err := ics.ParseCalendar(reader)
 			if err != nil {
-				// TODO: Find a way to log this and continue.
-				return nil
+				return nil, err
 			}
-			return calendar
+			return calendar, nil
 		}
 	}
-	return nil
+	return nil, nil
 }
-func marshalCalendar(cont *vmContainer, calendar *ics.Calendar) (goja.Value, error) {
+
+// calendarMethod returns the iTIP method of the calendar, such as
+// REQUEST, CANCEL or REPLY.
+func calendarMethod(calendar *ics.Calendar) string {
+	for _, prop := range calendar.CalendarProperties {
+		if prop.IANAToken == string(ics.PropertyMethod) {
+			return strings.ToUpper(prop.Value)
+		}
+	}
+	return ""
+}
+
+func marshalCalendar(cont *vmContainer, calendar *ics.Calendar, parseErr error) (goja.Value, error) {
 	if cont.calendarProto == nil {
 		proto, err := jsCalendarPrototype(cont.vm)
 		if err != nil {
@@ -36,18 +50,28 @@ func marshalCalendar(cont *vmContainer, calendar *ics.Calendar) (goja.Value, err
 		}
 		cont.calendarProto = proto
 	}
-	list := calendar.Events()
+	c := &jsCalendar{
+		method:     goja.Null(),
+		parseError: goja.Null(),
+	}
+	var list []*ics.VEvent
+	if parseErr != nil {
+		c.parseError = cont.vm.ToValue(parseErr.Error())
+	} else {
+		list = calendar.Events()
+		if method := calendarMethod(calendar); method != "" {
+			c.method = cont.vm.ToValue(method)
+		}
+	}
 	events := make([]goja.Value, len(list))
 	for i := range list {
-		event, err := marshalCalendarEvent(cont, list[i])
+		event, err := marshalCalendarEvent(cont, calendar, list[i])
 		if err != nil {
 			return nil, err
 		}
 		events[i] = event
 	}
-	c := &jsCalendar{
-		events: cont.vm.ToValue(events),
-	}
+	c.events = cont.vm.ToValue(events)
 	o := cont.vm.ToValue(c).ToObject(cont.vm)
 	err := o.SetPrototype(cont.calendarProto)
 	if err != nil {
@@ -57,7 +81,9 @@ func marshalCalendar(cont *vmContainer, calendar *ics.Calendar) (goja.Value, err
 }
 
 type jsCalendar struct {
-	events goja.Value
+	method     goja.Value
+	events     goja.Value
+	parseError goja.Value
 }
 
 func jsCalendarPrototype(vm *goja.Runtime) (*goja.Object, error) {
@@ -71,12 +97,30 @@ func jsCalendarPrototype(vm *goja.Runtime) (*goja.Object, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = defineGetterProperty(vm, proto, "method", func(_ *goja.Runtime, this any) (goja.Value, error) {
+		if this, ok := this.(*jsCalendar); ok {
+			return this.method, nil
+		}
+		return nil, errUnexpectedThisType(&jsCalendar{}, this)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineGetterProperty(vm, proto, "parseError", func(_ *goja.Runtime, this any) (goja.Value, error) {
+		if this, ok := this.(*jsCalendar); ok {
+			return this.parseError, nil
+		}
+		return nil, errUnexpectedThisType(&jsCalendar{}, this)
+	})
+	if err != nil {
+		return nil, err
+	}
 	return proto, nil
 }
 
-func marshalCalendarEvent(cont *vmContainer, event *ics.VEvent) (goja.Value, error) {
+func marshalCalendarEvent(cont *vmContainer, calendar *ics.Calendar, event *ics.VEvent) (goja.Value, error) {
 	if cont.calendarEventProto == nil {
-		proto, err := jsCalendarEventPrototype(cont.vm)
+		proto, err := jsCalendarEventPrototype(cont)
 		if err != nil {
 			return nil, err
 		}
@@ -96,6 +140,8 @@ func marshalCalendarEvent(cont *vmContainer, event *ics.VEvent) (goja.Value, err
 		attendees[i] = attendee
 	}
 	c := &jsCalendarEvent{
+		calendar:  calendar,
+		event:     event,
 		summary:   summary,
 		attendees: cont.vm.ToValue(attendees),
 	}
@@ -107,7 +153,8 @@ func marshalCalendarEvent(cont *vmContainer, event *ics.VEvent) (goja.Value, err
 	return o, nil
 }
 
-func jsCalendarEventPrototype(vm *goja.Runtime) (*goja.Object, error) {
+func jsCalendarEventPrototype(cont *vmContainer) (*goja.Object, error) {
+	vm := cont.vm
 	proto := vm.NewObject()
 	err := defineGetterProperty(vm, proto, "summary", func(_ *goja.Runtime, this any) (goja.Value, error) {
 		if this, ok := this.(*jsCalendarEvent); ok {
@@ -127,12 +174,121 @@ func jsCalendarEventPrototype(vm *goja.Runtime) (*goja.Object, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = jsCalendarEventPrototypeDefineDetailProps(cont, proto)
+	if err != nil {
+		return nil, err
+	}
 	return proto, nil
 }
 
 type jsCalendarEvent struct {
+	calendar *ics.Calendar
+	event    *ics.VEvent
+
 	summary   goja.Value
 	attendees goja.Value
+
+	// Lazily computed details.
+	start       goja.Value // Date
+	end         goja.Value // Date
+	allDay      goja.Value // boolean
+	location    goja.Value // string
+	organizer   goja.Value // mailbox
+	uid         goja.Value // string
+	sequence    goja.Value // number
+	status      goja.Value // string
+	rrule       goja.Value // string
+	description goja.Value // string
+}
+
+func jsCalendarEventPrototypeDefineDetailProps(cont *vmContainer, proto *goja.Object) error {
+	vm := cont.vm
+	textProps := []struct {
+		propName string
+		property ics.Property
+	}{
+		{"location", ics.PropertyLocation},
+		{"uid", ics.PropertyUid},
+		{"status", ics.PropertyStatus},
+		{"rrule", ics.PropertyRrule},
+		{"description", ics.PropertyDescription},
+	}
+	for _, prop := range textProps {
+		property := prop.property
+		err := defineCachedProperty(vm, proto, prop.propName, func(e *jsCalendarEvent) (goja.Value, error) {
+			if value := e.event.GetProperty(ics.ComponentProperty(property)); value != nil {
+				return vm.ToValue(ics.FromText(value.Value)), nil
+			}
+			return goja.Null(), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := defineCachedProperty(vm, proto, "start", func(e *jsCalendarEvent) (goja.Value, error) {
+		start, _, _, err := eventTimes(e.calendar, e.event)
+		if err != nil {
+			return goja.Null(), nil
+		}
+		return marshalDate(vm, start)
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "end", func(e *jsCalendarEvent) (goja.Value, error) {
+		_, end, _, err := eventTimes(e.calendar, e.event)
+		if err != nil {
+			return goja.Null(), nil
+		}
+		return marshalDate(vm, end)
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "allDay", func(e *jsCalendarEvent) (goja.Value, error) {
+		_, _, allDay, err := eventTimes(e.calendar, e.event)
+		if err != nil {
+			return goja.Null(), nil
+		}
+		return vm.ToValue(allDay), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "organizer", func(e *jsCalendarEvent) (goja.Value, error) {
+		value := e.event.GetProperty(ics.ComponentPropertyOrganizer)
+		if value == nil {
+			return goja.Null(), nil
+		}
+		address := mail.Address{
+			Address: calendarAddress(value.Value),
+		}
+		if list := value.ICalParameters[string(ics.ParameterCn)]; len(list) > 0 {
+			address.Name = list[0]
+		}
+		return marshalMailbox(cont, &address)
+	})
+	if err != nil {
+		return err
+	}
+	return defineCachedProperty(vm, proto, "sequence", func(e *jsCalendarEvent) (goja.Value, error) {
+		value := e.event.GetProperty(ics.ComponentPropertySequence)
+		if value == nil {
+			return vm.ToValue(0), nil
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value.Value))
+		if err != nil {
+			return goja.Null(), nil
+		}
+		return vm.ToValue(n), nil
+	})
+}
+
+func calendarAddress(value string) string {
+	if strings.HasPrefix(value, "MAILTO:") || strings.HasPrefix(value, "mailto:") {
+		return value[7:]
+	}
+	return value
 }
 
 func marshalCalendarAttendee(cont *vmContainer, attendee *ics.Attendee) (goja.Value, error) {
@@ -143,12 +299,8 @@ func marshalCalendarAttendee(cont *vmContainer, attendee *ics.Attendee) (goja.Va
 		}
 		cont.calendarAttendeeProto = proto
 	}
-	email := attendee.Value
-	if strings.HasPrefix(email, "MAILTO:") || strings.HasPrefix(email, "mailto:") {
-		email = email[7:]
-	}
 	address := mail.Address{
-		Address: email,
+		Address: calendarAddress(attendee.Value),
 	}
 	if list := attendee.ICalParameters[string(ics.ParameterCn)]; len(list) > 0 {
 		address.Name = list[0]
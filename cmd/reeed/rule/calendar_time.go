@@ -0,0 +1,248 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// eventTimes returns the start and end of a calendar event, resolving
+// TZID parameters against the IANA time zone database, common Windows
+// time zone names, and the calendar's own VTIMEZONE components, in that
+// order. If the event has no DTEND, the end is computed from DURATION,
+// or is the same as the start. Times without a time zone are floating
+// and are interpreted in the local time zone.
+func eventTimes(calendar *ics.Calendar, event *ics.VEvent) (start, end time.Time, allDay bool, err error) {
+	startProp := event.GetProperty(ics.ComponentPropertyDtStart)
+	if startProp == nil {
+		err = errors.New("event has no DTSTART")
+		return
+	}
+	start, allDay, err = calendarTime(calendar, startProp)
+	if err != nil {
+		return
+	}
+	if endProp := event.GetProperty(ics.ComponentPropertyDtEnd); endProp != nil {
+		end, _, err = calendarTime(calendar, endProp)
+		return
+	}
+	if durationProp := event.GetProperty(ics.ComponentProperty(ics.PropertyDuration)); durationProp != nil {
+		var d time.Duration
+		d, err = parseCalendarDuration(durationProp.Value)
+		end = start.Add(d)
+		return
+	}
+	if allDay {
+		end = start.AddDate(0, 0, 1)
+	} else {
+		end = start
+	}
+	return
+}
+
+func calendarTime(calendar *ics.Calendar, prop *ics.IANAProperty) (t time.Time, allDay bool, err error) {
+	value := strings.TrimSpace(prop.Value)
+	loc := time.Local
+	if tzid := prop.ICalParameters[string(ics.ParameterTzid)]; len(tzid) > 0 {
+		loc = resolveCalendarTZ(calendar, tzid[0])
+	}
+	isDate := len(value) == 8
+	if v := prop.ICalParameters[string(ics.ParameterValue)]; len(v) > 0 && strings.EqualFold(v[0], "DATE") {
+		isDate = true
+	}
+	switch {
+	case isDate:
+		if len(value) > 8 {
+			value = value[:8]
+		}
+		t, err = time.ParseInLocation("20060102", value, loc)
+		allDay = true
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.ParseInLocation("20060102T150405Z", value, time.UTC)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid %s value %q", prop.IANAToken, value)
+	}
+	return
+}
+
+// windowsTimeZones maps common Windows time zone names, as used by
+// Outlook and Exchange, to IANA time zone names.
+var windowsTimeZones = map[string]string{
+	"Dateline Standard Time":          "Etc/GMT+12",
+	"Hawaiian Standard Time":          "Pacific/Honolulu",
+	"Alaskan Standard Time":           "America/Anchorage",
+	"Pacific Standard Time":           "America/Los_Angeles",
+	"US Mountain Standard Time":       "America/Phoenix",
+	"Mountain Standard Time":          "America/Denver",
+	"Central Standard Time":           "America/Chicago",
+	"Canada Central Standard Time":    "America/Regina",
+	"Eastern Standard Time":           "America/New_York",
+	"Atlantic Standard Time":          "America/Halifax",
+	"Newfoundland Standard Time":      "America/St_Johns",
+	"E. South America Standard Time":  "America/Sao_Paulo",
+	"UTC":                             "UTC",
+	"GMT Standard Time":               "Europe/London",
+	"Greenwich Standard Time":         "Atlantic/Reykjavik",
+	"W. Europe Standard Time":         "Europe/Berlin",
+	"Romance Standard Time":           "Europe/Paris",
+	"Central Europe Standard Time":    "Europe/Budapest",
+	"Central European Standard Time":  "Europe/Warsaw",
+	"E. Europe Standard Time":         "Europe/Chisinau",
+	"GTB Standard Time":               "Europe/Bucharest",
+	"FLE Standard Time":               "Europe/Kiev",
+	"Israel Standard Time":            "Asia/Jerusalem",
+	"South Africa Standard Time":      "Africa/Johannesburg",
+	"Russian Standard Time":           "Europe/Moscow",
+	"Arabian Standard Time":           "Asia/Dubai",
+	"India Standard Time":             "Asia/Kolkata",
+	"China Standard Time":             "Asia/Shanghai",
+	"Singapore Standard Time":         "Asia/Singapore",
+	"Tokyo Standard Time":             "Asia/Tokyo",
+	"Korea Standard Time":             "Asia/Seoul",
+	"AUS Eastern Standard Time":       "Australia/Sydney",
+	"E. Australia Standard Time":      "Australia/Brisbane",
+	"Cen. Australia Standard Time":    "Australia/Adelaide",
+	"W. Australia Standard Time":      "Australia/Perth",
+	"New Zealand Standard Time":       "Pacific/Auckland",
+	"SA Pacific Standard Time":        "America/Bogota",
+	"Argentina Standard Time":         "America/Argentina/Buenos_Aires",
+	"Central America Standard Time":   "America/Guatemala",
+	"Mexico Standard Time":            "America/Mexico_City",
+	"Central Standard Time (Mexico)":  "America/Mexico_City",
+	"Pacific Standard Time (Mexico)":  "America/Tijuana",
+	"Mountain Standard Time (Mexico)": "America/Chihuahua",
+}
+
+func resolveCalendarTZ(calendar *ics.Calendar, tzid string) *time.Location {
+	name := strings.Trim(tzid, `"`)
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc
+	}
+	if iana, ok := windowsTimeZones[name]; ok {
+		if loc, err := time.LoadLocation(iana); err == nil {
+			return loc
+		}
+	}
+	if loc := vtimezoneLocation(calendar, name); loc != nil {
+		return loc
+	}
+	return time.Local
+}
+
+// vtimezoneLocation approximates a VTIMEZONE component by a fixed zone
+// having its standard time offset. This ignores daylight saving time,
+// but is only used if the time zone is otherwise unknown.
+func vtimezoneLocation(calendar *ics.Calendar, tzid string) *time.Location {
+	if calendar == nil {
+		return nil
+	}
+	for _, c := range calendar.Components {
+		tz, ok := c.(*ics.VTimezone)
+		if !ok {
+			continue
+		}
+		if p := tz.GetProperty(ics.ComponentProperty(ics.PropertyTzid)); p == nil || p.Value != tzid {
+			continue
+		}
+		for _, sub := range tz.Components {
+			standard, ok := sub.(*ics.Standard)
+			if !ok {
+				continue
+			}
+			p := standard.GetProperty(ics.ComponentProperty(ics.PropertyTzoffsetto))
+			if p == nil {
+				continue
+			}
+			if offset, ok := parseUTCOffset(p.Value); ok {
+				return time.FixedZone(tzid, offset)
+			}
+		}
+	}
+	return nil
+}
+
+// parseUTCOffset parses an iCalendar UTC offset of the form +HHMM or
+// +HHMMSS into seconds east of UTC.
+func parseUTCOffset(value string) (int, bool) {
+	if len(value) != 5 && len(value) != 7 {
+		return 0, false
+	}
+	sign := 1
+	switch value[0] {
+	case '+':
+	case '-':
+		sign = -1
+	default:
+		return 0, false
+	}
+	var parts [3]int
+	for i := 0; 1+2*i < len(value); i++ {
+		n, err := strconv.Atoi(value[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, false
+		}
+		parts[i] = n
+	}
+	return sign * (parts[0]*3600 + parts[1]*60 + parts[2]), true
+}
+
+// parseCalendarDuration parses an RFC 5545 duration such as P1D,
+// PT1H30M or -P1W.
+func parseCalendarDuration(value string) (time.Duration, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	s = s[1:]
+	var d time.Duration
+	inTime := false
+	for len(s) > 0 {
+		if s[0] == 'T' {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(s) {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		unit := time.Duration(n)
+		switch {
+		case s[i] == 'W' && !inTime:
+			d += unit * 7 * 24 * time.Hour
+		case s[i] == 'D' && !inTime:
+			d += unit * 24 * time.Hour
+		case s[i] == 'H' && inTime:
+			d += unit * time.Hour
+		case s[i] == 'M' && inTime:
+			d += unit * time.Minute
+		case s[i] == 'S' && inTime:
+			d += unit * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		s = s[i+1:]
+	}
+	return sign * d, nil
+}
@@ -0,0 +1,147 @@
+package rule
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dop251/goja"
+	"github.com/gogama/reee-evolution/daemon"
+)
+
+// installClassifierFunc makes the naive Bayes classifiers in the
+// message store available to JavaScript as reee.classifier(name).
+// Classifiers are bound to the evaluation of the message passed to them,
+// and training only takes effect if the evaluation succeeds.
+func installClassifierFunc(cont *vmContainer, reeeObject *goja.Object) error {
+	vm := cont.vm
+	return reeeObject.Set("classifier", vm.ToValue(func(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+		name, ok := call.Argument(0).Export().(string)
+		if !ok || name == "" {
+			throwJSException(vm, "reeed: classifier() requires a non-empty name")
+		}
+		v, err := marshalClassifier(cont, name)
+		if err != nil {
+			throwJSException(vm, err)
+		}
+		return v
+	}))
+}
+
+type jsClassifier struct {
+	cont *vmContainer
+
+	name goja.Value // string
+}
+
+func marshalClassifier(cont *vmContainer, name string) (goja.Value, error) {
+	if cont.classifierProto == nil {
+		proto, err := jsClassifierPrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.classifierProto = proto
+	}
+	c := &jsClassifier{
+		cont: cont,
+		name: cont.vm.ToValue(name),
+	}
+	o := cont.vm.ToValue(c).ToObject(cont.vm)
+	err := o.SetPrototype(cont.classifierProto)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func jsClassifierPrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	err := defineGetterProperty(vm, proto, "name", func(_ *goja.Runtime, this any) (goja.Value, error) {
+		if this, ok := this.(*jsClassifier); ok {
+			return this.name, nil
+		}
+		return nil, errUnexpectedThisType(&jsClassifier{}, this)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = proto.Set("score", vm.ToValue(jsClassifierScore))
+	if err != nil {
+		return nil, err
+	}
+	err = proto.Set("train", vm.ToValue(jsClassifierTrain))
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
+
+// jsClassifierArgs unpacks the receiver and message argument of a
+// classifier method, and returns the classifier of the evaluation the
+// message belongs to.
+func jsClassifierArgs(call goja.FunctionCall, vm *goja.Runtime, method string) (*jsClassifier, *jsMessage, daemon.Classifier) {
+	c, ok := call.This.Export().(*jsClassifier)
+	if !ok {
+		throwJSException(vm, errUnexpectedThisType(c, call.This.Export()))
+	}
+	m, ok := call.Argument(0).Export().(*jsMessage)
+	if !ok {
+		throwJSException(vm, errUnexpectedArgType(0, m, call.Argument(0).Export()))
+	}
+	classifier, ok := m.evalCtx.(daemon.Classifier)
+	if !ok {
+		throwJSException(vm, fmt.Sprintf("reeed: classifier.%s() may only be called while evaluating a rule", method))
+	}
+	return c, m, classifier
+}
+
+func jsClassifierScore(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	c, m, classifier := jsClassifierArgs(call, vm, "score")
+	tokens := m.getClassifierTokens()
+	counts, err := classifier.GetClassifierCounts(c.name.String(), tokens)
+	if err != nil {
+		throwJSException(vm, fmt.Sprintf("reeed: classifier.score() failed: %s", err))
+	}
+	scores := counts.Score(tokens)
+	labels := make([]string, 0, len(scores))
+	for label := range scores {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	result := vm.NewObject()
+	scoresObject := vm.NewObject()
+	best, bestP := goja.Null(), 0.0
+	for _, label := range labels {
+		p := scores[label]
+		_ = scoresObject.Set(label, p)
+		if p > bestP {
+			best, bestP = vm.ToValue(label), p
+		}
+	}
+	_ = result.Set("label", best)
+	_ = result.Set("probability", bestP)
+	_ = result.Set("scores", scoresObject)
+	return result
+}
+
+func jsClassifierTrain(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	c, m, classifier := jsClassifierArgs(call, vm, "train")
+	label, ok := call.Argument(1).Export().(string)
+	if !ok || label == "" {
+		throwJSException(vm, "reeed: classifier.train() requires a non-empty label")
+	}
+	if m.tagger == nil {
+		throwJSException(vm, "reeed: classifier.train() can only train the message being evaluated")
+	}
+	err := classifier.TrainClassifier(c.name.String(), label, m.getClassifierTokens())
+	if err != nil {
+		throwJSException(vm, fmt.Sprintf("reeed: classifier.train() failed: %s", err))
+	}
+	return goja.Undefined()
+}
+
+func (m *jsMessage) getClassifierTokens() []string {
+	if m.classifierTokens == nil {
+		m.classifierTokens = daemon.ClassifierTokens(m.msg)
+	}
+	return m.classifierTokens
+}
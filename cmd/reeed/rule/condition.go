@@ -0,0 +1,297 @@
+package rule
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/gogama/reee-evolution/daemon"
+)
+
+type condition func(msg *daemon.Message, tagger daemon.Tagger) bool
+
+type stringTest func(s string) bool
+
+func compileCondition(v any, path string) (condition, error) {
+	m, err := declMap(v, path)
+	if err != nil {
+		return nil, err
+	} else if len(m) != 1 {
+		return nil, fmt.Errorf("condition at %s must have exactly one property, but has %d", path, len(m))
+	}
+	for key, arg := range m {
+		path = path + "." + key
+		switch key {
+		case "all", "any":
+			return compileJunction(key == "all", arg, path)
+		case "not":
+			c, err := compileCondition(arg, path)
+			if err != nil {
+				return nil, err
+			}
+			return func(msg *daemon.Message, tagger daemon.Tagger) bool {
+				return !c(msg, tagger)
+			}, nil
+		case "header":
+			return compileHeaderCondition(arg, path)
+		case "subject":
+			t, err := compileStringTest(arg, path)
+			if err != nil {
+				return nil, err
+			}
+			return func(msg *daemon.Message, _ daemon.Tagger) bool {
+				return t(msg.Envelope.GetHeader("Subject"))
+			}, nil
+		case "body":
+			t, err := compileStringTest(arg, path)
+			if err != nil {
+				return nil, err
+			}
+			return func(msg *daemon.Message, _ daemon.Tagger) bool {
+				return t(msg.Envelope.Text) || t(msg.Envelope.HTML)
+			}, nil
+		case "attachment":
+			return compileAttachmentCondition(arg, path)
+		case "tag":
+			return compileTagCondition(arg, path)
+		default:
+			for _, prop := range jsMessageMailboxHeaderProps {
+				if key == prop.propName {
+					return compileAddressCondition(prop.headerName, arg, path)
+				}
+			}
+			return nil, fmt.Errorf("unknown condition %s", path)
+		}
+	}
+	panic("unreachable")
+}
+
+func compileJunction(all bool, v any, path string) (condition, error) {
+	list, err := declList(v, path)
+	if err != nil {
+		return nil, err
+	}
+	cs := make([]condition, len(list))
+	for i := range list {
+		cs[i], err = compileCondition(list[i], fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return func(msg *daemon.Message, tagger daemon.Tagger) bool {
+		for _, c := range cs {
+			if c(msg, tagger) != all {
+				return !all
+			}
+		}
+		return all
+	}, nil
+}
+
+func compileHeaderCondition(v any, path string) (condition, error) {
+	m, err := declMap(v, path)
+	if err != nil {
+		return nil, err
+	}
+	var name string
+	test := func(string) bool { return true }
+	for key, value := range m {
+		switch key {
+		case "name":
+			name, err = declString(value, path+".name")
+		case "value":
+			test, err = compileStringTest(value, path+".value")
+		default:
+			err = fmt.Errorf("unknown property %s for header condition at %s", key, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("missing header name at %s", path)
+	}
+	return func(msg *daemon.Message, _ daemon.Tagger) bool {
+		for _, value := range msg.Envelope.GetHeaderValues(name) {
+			if test(value) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func compileAddressCondition(headerName string, v any, path string) (condition, error) {
+	m, err := declMap(v, path)
+	if err != nil {
+		return nil, err
+	}
+	var tests []func(*mail.Address) bool
+	for key, value := range m {
+		var t stringTest
+		t, err = compileStringTest(value, path+"."+key)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "name":
+			tests = append(tests, func(addr *mail.Address) bool {
+				return t(addr.Name)
+			})
+		case "address":
+			tests = append(tests, func(addr *mail.Address) bool {
+				return t(addr.Address)
+			})
+		case "localPart":
+			tests = append(tests, func(addr *mail.Address) bool {
+				i := strings.LastIndexByte(addr.Address, '@')
+				return i >= 0 && t(addr.Address[0:i])
+			})
+		case "domain":
+			tests = append(tests, func(addr *mail.Address) bool {
+				i := strings.LastIndexByte(addr.Address, '@')
+				return i >= 0 && t(addr.Address[i+1:])
+			})
+		default:
+			return nil, fmt.Errorf("unknown property %s for address condition at %s", key, path)
+		}
+	}
+	return func(msg *daemon.Message, _ daemon.Tagger) bool {
+		list, err := msg.Envelope.AddressList(headerName)
+		if err != nil {
+			return false
+		}
+	next:
+		for _, addr := range list {
+			for _, t := range tests {
+				if !t(addr) {
+					continue next
+				}
+			}
+			return true
+		}
+		return false
+	}, nil
+}
+
+func compileAttachmentCondition(v any, path string) (condition, error) {
+	m, err := declMap(v, path)
+	if err != nil {
+		return nil, err
+	}
+	fileName := func(string) bool { return true }
+	contentType := func(string) bool { return true }
+	for key, value := range m {
+		switch key {
+		case "fileName":
+			fileName, err = compileStringTest(value, path+".fileName")
+		case "contentType":
+			contentType, err = compileStringTest(value, path+".contentType")
+		default:
+			err = fmt.Errorf("unknown property %s for attachment condition at %s", key, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return func(msg *daemon.Message, _ daemon.Tagger) bool {
+		for _, part := range msg.Envelope.Attachments {
+			if fileName(part.FileName) && contentType(part.ContentType) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func compileTagCondition(v any, path string) (condition, error) {
+	m, err := declMap(v, path)
+	if err != nil {
+		return nil, err
+	}
+	var key string
+	test := func(string) bool { return true }
+	for k, value := range m {
+		switch k {
+		case "key":
+			key, err = declString(value, path+".key")
+		case "value":
+			test, err = compileStringTest(value, path+".value")
+		default:
+			err = fmt.Errorf("unknown property %s for tag condition at %s", k, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("missing tag key at %s", path)
+	}
+	return func(_ *daemon.Message, tagger daemon.Tagger) bool {
+		value, ok := tagger.GetTag(key)
+		return ok && test(value)
+	}, nil
+}
+
+// compileStringTest compiles a string test. A plain string value is a
+// case-insensitive equality test. An object value may combine the
+// case-insensitive tests equals, contains, prefix and suffix with a
+// regex test, all of which must pass.
+func compileStringTest(v any, path string) (stringTest, error) {
+	if s, ok := v.(string); ok {
+		return func(value string) bool {
+			return strings.EqualFold(s, value)
+		}, nil
+	}
+	m, err := declMap(v, path)
+	if err != nil {
+		return nil, err
+	} else if len(m) == 0 {
+		return nil, fmt.Errorf("empty string test at %s", path)
+	}
+	var tests []stringTest
+	for key, value := range m {
+		var s string
+		s, err = declString(value, path+"."+key)
+		if err != nil {
+			return nil, err
+		}
+		lower := strings.ToLower(s)
+		switch key {
+		case "equals":
+			tests = append(tests, func(value string) bool {
+				return strings.EqualFold(s, value)
+			})
+		case "contains":
+			tests = append(tests, func(value string) bool {
+				return strings.Contains(strings.ToLower(value), lower)
+			})
+		case "prefix":
+			tests = append(tests, func(value string) bool {
+				return strings.HasPrefix(strings.ToLower(value), lower)
+			})
+		case "suffix":
+			tests = append(tests, func(value string) bool {
+				return strings.HasSuffix(strings.ToLower(value), lower)
+			})
+		case "regex":
+			var re *regexp.Regexp
+			re, err = regexp.Compile(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex at %s: %s", path+"."+key, err)
+			}
+			tests = append(tests, re.MatchString)
+		default:
+			return nil, fmt.Errorf("unknown string test %s at %s", key, path)
+		}
+	}
+	return func(value string) bool {
+		for _, t := range tests {
+			if !t(value) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
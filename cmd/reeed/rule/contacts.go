@@ -0,0 +1,394 @@
+package rule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/gogama/reee-evolution/log"
+)
+
+// Contacts is an address book loaded from one or more vCard files,
+// indexed by email address, which is reloaded when the files change.
+type Contacts struct {
+	r *reloader[map[string]*contact]
+}
+
+// NewContacts loads the contacts from the given vCard files and
+// directories. Directories are searched recursively for files having
+// the extension .vcf or .vcard.
+func NewContacts(logger log.Printer, paths []string) (*Contacts, error) {
+	r, err := newReloader("contacts", paths, []string{".vcf", ".vcard"}, logger, loadContacts)
+	if err != nil {
+		return nil, fmt.Errorf("reeed: failed to load contacts: %w", err)
+	}
+	return &Contacts{r: r}, nil
+}
+
+// lookup returns the contact having an email address. The local part
+// and the domain are compared case-insensitively.
+func (c *Contacts) lookup(address string) *contact {
+	if c == nil {
+		return nil
+	}
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 {
+		return nil
+	}
+	return c.r.get()[normalizeAddress(address[:i], address[i+1:])]
+}
+
+type contact struct {
+	uid        string
+	name       string
+	emails     []string
+	categories []string
+}
+
+// loadContacts indexes the contacts in a set of vCard files by email
+// address. If more than one contact has the same address, the first one
+// wins.
+func loadContacts(files []string) (map[string]*contact, error) {
+	index := make(map[string]*contact)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		contacts, err := parseVCards(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, c := range contacts {
+			for _, email := range c.emails {
+				i := strings.LastIndexByte(email, '@')
+				if i < 0 {
+					continue
+				}
+				key := normalizeAddress(email[:i], email[i+1:])
+				if _, ok := index[key]; !ok {
+					index[key] = c
+				}
+			}
+		}
+	}
+	return index, nil
+}
+
+// parseVCards parses the vCards in a file. Only the properties needed
+// to identify a contact by email address are kept. vCard versions 2.1,
+// 3.0 and 4.0 are understood, including the quoted-printable values
+// which version 2.1 allows.
+func parseVCards(r io.Reader) ([]*contact, error) {
+	lines, err := unfoldVCardLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var contacts []*contact
+	var c *contact
+	var n string
+	var org string
+	for _, line := range lines {
+		name, params, value, ok := splitVCardLine(line)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(params["ENCODING"], "QUOTED-PRINTABLE") || params["QUOTED-PRINTABLE"] != "" {
+			if b, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(value))); err == nil {
+				value = string(b)
+			}
+		}
+		switch name {
+		case "BEGIN":
+			if strings.EqualFold(value, "VCARD") {
+				c, n, org = &contact{}, "", ""
+			}
+		case "END":
+			if strings.EqualFold(value, "VCARD") && c != nil {
+				if c.name == "" {
+					c.name = n
+				}
+				if c.name == "" {
+					c.name = org
+				}
+				contacts = append(contacts, c)
+				c = nil
+			}
+		}
+		if c == nil {
+			continue
+		}
+		switch name {
+		case "UID":
+			c.uid = unescapeVCardValue(value)
+		case "FN":
+			c.name = unescapeVCardValue(value)
+		case "N":
+			n = vCardStructuredName(value)
+		case "ORG":
+			if parts := splitVCardValue(value, ';'); len(parts) > 0 {
+				org = parts[0]
+			}
+		case "EMAIL":
+			email := strings.TrimSpace(unescapeVCardValue(value))
+			if len(email) > 7 && strings.EqualFold(email[:7], "mailto:") {
+				email = email[7:]
+			}
+			if addr, err := mail.ParseAddress(email); err == nil {
+				email = addr.Address
+			}
+			if email != "" {
+				c.emails = append(c.emails, email)
+			}
+		case "CATEGORIES":
+			for _, category := range splitVCardValue(value, ',') {
+				if category = strings.TrimSpace(category); category != "" {
+					c.categories = append(c.categories, category)
+				}
+			}
+		}
+	}
+	return contacts, nil
+}
+
+// unfoldVCardLines reads the logical lines of a vCard file. A physical
+// line starting with a space or a tab continues the previous line, and
+// so does the line after a quoted-printable soft line break.
+func unfoldVCardLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	qpSoftBreak := false
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case len(lines) > 0 && qpSoftBreak:
+			last := lines[len(lines)-1]
+			lines[len(lines)-1] = last[:len(last)-1] + line
+		case len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")):
+			lines[len(lines)-1] += line[1:]
+		default:
+			lines = append(lines, line)
+		}
+		last := lines[len(lines)-1]
+		colon := strings.IndexByte(last, ':')
+		qpSoftBreak = colon >= 0 && strings.HasSuffix(last, "=") && strings.Contains(strings.ToUpper(last[:colon]), "QUOTED-PRINTABLE")
+	}
+	return lines, scanner.Err()
+}
+
+// splitVCardLine splits a content line into its upper-case property
+// name, with any group prefix removed, its parameters and its value.
+// Parameters without a name, such as vCard 2.1 types, are keyed by the
+// upper-case parameter value.
+func splitVCardLine(line string) (name string, params map[string]string, value string, ok bool) {
+	quoted := false
+	colon := -1
+	for i := 0; i < len(line) && colon < 0; i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				colon = i
+			}
+		}
+	}
+	if colon < 0 {
+		return
+	}
+	fields := strings.Split(line[:colon], ";")
+	name = strings.ToUpper(fields[0])
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	params = make(map[string]string, len(fields)-1)
+	for _, param := range fields[1:] {
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			params[strings.ToUpper(param[:i])] = strings.Trim(param[i+1:], `"`)
+		} else {
+			params[strings.ToUpper(param)] = param
+		}
+	}
+	return name, params, line[colon+1:], true
+}
+
+// splitVCardValue splits a value on unescaped separators and unescapes
+// each part.
+func splitVCardValue(value string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' {
+			i++
+		} else if value[i] == sep {
+			parts = append(parts, unescapeVCardValue(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, unescapeVCardValue(value[start:]))
+}
+
+func unescapeVCardValue(value string) string {
+	if !strings.ContainsRune(value, '\\') {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// vCardStructuredName formats the family, given, additional, prefix
+// and suffix components of an N property as a display name.
+func vCardStructuredName(value string) string {
+	parts := splitVCardValue(value, ';')
+	for len(parts) < 5 {
+		parts = append(parts, "")
+	}
+	var words []string
+	for _, i := range []int{3, 1, 2, 0, 4} {
+		if w := strings.TrimSpace(parts[i]); w != "" {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// installContactsObject makes the address book available to JavaScript
+// as reee.contacts.
+func installContactsObject(cont *vmContainer, reeeObject *goja.Object) error {
+	vm := cont.vm
+	contactsObject := vm.NewObject()
+	err := contactsObject.Set("lookup", vm.ToValue(func(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+		var address string
+		switch x := call.Argument(0).Export().(type) {
+		case *jsMailbox:
+			address = x.mailbox.Address
+		case string:
+			address = x
+			if addr, err := mail.ParseAddress(x); err == nil {
+				address = addr.Address
+			}
+		default:
+			throwJSException(vm, errUnexpectedArgType(0, "", x))
+		}
+		v, err := marshalContact(cont, cont.contacts.lookup(address))
+		if err != nil {
+			throwJSException(vm, err)
+		}
+		return v
+	}))
+	if err != nil {
+		return err
+	}
+	return reeeObject.Set("contacts", contactsObject)
+}
+
+// jsMailboxPrototypeDefineContactProps adds the address book properties
+// to the mailbox prototype.
+func jsMailboxPrototypeDefineContactProps(cont *vmContainer, proto *goja.Object) error {
+	vm := cont.vm
+	err := defineCachedProperty(vm, proto, "isContact", func(m *jsMailbox) (goja.Value, error) {
+		return vm.ToValue(cont.contacts.lookup(m.mailbox.Address) != nil), nil
+	})
+	if err != nil {
+		return err
+	}
+	return defineCachedProperty(vm, proto, "contact", func(m *jsMailbox) (goja.Value, error) {
+		return marshalContact(cont, cont.contacts.lookup(m.mailbox.Address))
+	})
+}
+
+type jsContact struct {
+	contact *contact
+
+	uid        goja.Value // string
+	name       goja.Value // string
+	emails     goja.Value // []string
+	categories goja.Value // []string
+}
+
+func marshalContact(cont *vmContainer, c *contact) (goja.Value, error) {
+	if c == nil {
+		return goja.Null(), nil
+	}
+	if cont.contactProto == nil {
+		proto, err := jsContactPrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.contactProto = proto
+	}
+	jc := &jsContact{
+		contact: c,
+	}
+	o := cont.vm.ToValue(jc).ToObject(cont.vm)
+	err := o.SetPrototype(cont.contactProto)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func jsContactPrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	stringProps := []struct {
+		propName string
+		get      func(*contact) string
+	}{
+		{"uid", func(c *contact) string { return c.uid }},
+		{"name", func(c *contact) string { return c.name }},
+	}
+	for _, prop := range stringProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(jc *jsContact) (goja.Value, error) {
+			if value := get(jc.contact); value != "" {
+				return vm.ToValue(value), nil
+			}
+			return goja.Null(), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	listProps := []struct {
+		propName string
+		get      func(*contact) []string
+	}{
+		{"emails", func(c *contact) []string { return c.emails }},
+		{"categories", func(c *contact) []string { return c.categories }},
+	}
+	for _, prop := range listProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(jc *jsContact) (goja.Value, error) {
+			values := get(jc.contact)
+			a := make([]any, len(values))
+			for i := range values {
+				a[i] = values[i]
+			}
+			return vm.NewArray(a...), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proto, nil
+}
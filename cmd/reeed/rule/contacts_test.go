@@ -0,0 +1,267 @@
+package rule
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseVCards(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected []*contact
+	}{
+		{
+			name: "apple contacts 3.0",
+			text: "BEGIN:VCARD\r\n" +
+				"VERSION:3.0\r\n" +
+				"PRODID:-//Apple Inc.//macOS 14.5//EN\r\n" +
+				"N:Appleseed;John;;;\r\n" +
+				"FN:John Appleseed\r\n" +
+				"ORG:Apple Inc.;\r\n" +
+				"item1.EMAIL;type=INTERNET;type=pref:john.appleseed@example.com\r\n" +
+				"item1.X-ABLabel:_$!<Other>!$_\r\n" +
+				"EMAIL;type=INTERNET;type=WORK:John@Work.Example\r\n" +
+				"TEL;type=CELL;type=VOICE;type=pref:+1 555 0100\r\n" +
+				"CATEGORIES:Work,Friends\r\n" +
+				"UID:6B29FC40-CA47-1067-B31D-00DD010662DA\r\n" +
+				"END:VCARD\r\n",
+			expected: []*contact{
+				{
+					uid:        "6B29FC40-CA47-1067-B31D-00DD010662DA",
+					name:       "John Appleseed",
+					emails:     []string{"john.appleseed@example.com", "John@Work.Example"},
+					categories: []string{"Work", "Friends"},
+				},
+			},
+		},
+		{
+			name: "google contacts 3.0 folded",
+			text: "BEGIN:VCARD\n" +
+				"VERSION:3.0\n" +
+				"FN:Jane Q. Public\n" +
+				"N:Public;Jane;Q.;Dr.;PhD\n" +
+				"EMAIL;TYPE=INTERNET;TYPE=HOME:jane.public@exa\n" +
+				" mple.org\n" +
+				"NOTE:Met at the conference\\, bring\\nslides\n" +
+				"CATEGORIES:myContacts,Conference\\, 2024\n" +
+				"END:VCARD\n",
+			expected: []*contact{
+				{
+					name:       "Jane Q. Public",
+					emails:     []string{"jane.public@example.org"},
+					categories: []string{"myContacts", "Conference, 2024"},
+				},
+			},
+		},
+		{
+			name: "vcard 4.0",
+			text: "BEGIN:VCARD\r\n" +
+				"VERSION:4.0\r\n" +
+				"UID:urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1\r\n" +
+				"N:Stevenson;John;Philip,Paul;Dr.;Jr.,M.D.,A.C.P.\r\n" +
+				"EMAIL;TYPE=work;PREF=1:jqpublic@xyz.example.com\r\n" +
+				"EMAIL:mailto:John Stevenson <john@home.example>\r\n" +
+				"END:VCARD\r\n",
+			expected: []*contact{
+				{
+					uid:    "urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1",
+					name:   "Dr. John Philip,Paul Stevenson Jr.,M.D.,A.C.P.",
+					emails: []string{"jqpublic@xyz.example.com", "john@home.example"},
+				},
+			},
+		},
+		{
+			name: "outlook 2.1 quoted-printable",
+			text: "BEGIN:VCARD\r\n" +
+				"VERSION:2.1\r\n" +
+				"N;CHARSET=utf-8;ENCODING=QUOTED-PRINTABLE:M=C3=BCller;J=C3=BCrgen\r\n" +
+				"FN;CHARSET=utf-8;ENCODING=QUOTED-PRINTABLE:J=C3=BCrgen M=C3=BCller (Vertrieb =\r\n" +
+				"Nord)\r\n" +
+				"EMAIL;PREF;INTERNET:juergen.mueller@example.de\r\n" +
+				"END:VCARD\r\n",
+			expected: []*contact{
+				{
+					name:   "Jürgen Müller (Vertrieb Nord)",
+					emails: []string{"juergen.mueller@example.de"},
+				},
+			},
+		},
+		{
+			name: "android 2.1 bare quoted-printable parameter",
+			text: "BEGIN:VCARD\n" +
+				"VERSION:2.1\n" +
+				"N;CHARSET=UTF-8;QUOTED-PRINTABLE:=E5=B1=B1=E7=94=B0;=E5=A4=AA=E9=83=8E;;;\n" +
+				"EMAIL;HOME:taro@example.jp\n" +
+				"END:VCARD\n",
+			expected: []*contact{
+				{
+					name:   "太郎 山田",
+					emails: []string{"taro@example.jp"},
+				},
+			},
+		},
+		{
+			name: "organization only",
+			text: "BEGIN:VCARD\nVERSION:3.0\nORG:Example Corp\\; Ltd;Sales\nEMAIL:sales@example.com\nEND:VCARD\n",
+			expected: []*contact{
+				{
+					name:   "Example Corp; Ltd",
+					emails: []string{"sales@example.com"},
+				},
+			},
+		},
+		{
+			name: "several cards",
+			text: "BEGIN:VCARD\nFN:A\nEMAIL:a@example.com\nEND:VCARD\n" +
+				"begin:vcard\nfn:B\nemail:b@example.com\nend:vcard\n",
+			expected: []*contact{
+				{name: "A", emails: []string{"a@example.com"}},
+				{name: "B", emails: []string{"b@example.com"}},
+			},
+		},
+		{
+			name: "properties outside a card",
+			text: "FN:Nobody\nEMAIL:nobody@example.com\nBEGIN:VCARD\nFN:C\nEND:VCARD\nEMAIL:stray@example.com\n",
+			expected: []*contact{
+				{name: "C"},
+			},
+		},
+		{
+			name: "unterminated card",
+			text: "BEGIN:VCARD\nFN:A\nEND:VCARD\nBEGIN:VCARD\nFN:B\nEMAIL:b@example.com\n",
+			expected: []*contact{
+				{name: "A"},
+			},
+		},
+		{
+			name: "quoted-printable soft break at end of file",
+			text: "BEGIN:VCARD\nFN:Jos\nEND:VCARD\n" +
+				"BEGIN:VCARD\nFN;ENCODING=QUOTED-PRINTABLE:Jos=C3=A9=",
+			expected: []*contact{
+				{name: "Jos"},
+			},
+		},
+		{
+			name: "trailing backslash",
+			text: "BEGIN:VCARD\nFN:Back\\\nORG:Slash\\\nCATEGORIES:a,b\\\nEND:VCARD\n",
+			expected: []*contact{
+				{name: "Back\\", categories: []string{"a", "b\\"}},
+			},
+		},
+		{
+			name: "malformed lines",
+			text: "BEGIN:VCARD\nthis line has no colon\n:no name\nEMAIL:\nEMAIL;TYPE=\"a:b\":quoted@example.com\nFN:\nEND:VCARD\n",
+			expected: []*contact{
+				{emails: []string{"quoted@example.com"}},
+			},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual, err := parseVCards(strings.NewReader(testCase.text))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(testCase.expected) != len(actual) {
+				t.Fatalf("expected %d contacts, got %d", len(testCase.expected), len(actual))
+			}
+			for i := range testCase.expected {
+				if !reflect.DeepEqual(testCase.expected[i], actual[i]) {
+					t.Errorf("contact %d: expected %+v, got %+v", i, *testCase.expected[i], *actual[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUnfoldVCardLines(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{
+			name:     "crlf",
+			text:     "BEGIN:VCARD\r\nFN:A\r\nEND:VCARD\r\n",
+			expected: []string{"BEGIN:VCARD", "FN:A", "END:VCARD"},
+		},
+		{
+			name:     "folded with space",
+			text:     "NOTE:This is a long \r\n  description\r\nFN:A",
+			expected: []string{"NOTE:This is a long  description", "FN:A"},
+		},
+		{
+			name:     "folded with tab",
+			text:     "EMAIL:a@exa\n\tmple.com\n",
+			expected: []string{"EMAIL:a@example.com"},
+		},
+		{
+			name:     "multiple folds",
+			text:     "NOTE:a\n b\n c\nFN:x\n",
+			expected: []string{"NOTE:abc", "FN:x"},
+		},
+		{
+			name:     "quoted-printable soft break",
+			text:     "NOTE;ENCODING=QUOTED-PRINTABLE:abc=\r\ndef=\r\nghi\r\nFN:x\r\n",
+			expected: []string{"NOTE;ENCODING=QUOTED-PRINTABLE:abcdefghi", "FN:x"},
+		},
+		{
+			name:     "bare quoted-printable parameter",
+			text:     "NOTE;quoted-printable:abc=\n def\n",
+			expected: []string{"NOTE;quoted-printable:abc def"},
+		},
+		{
+			name:     "quoted-printable soft break at end of file",
+			text:     "FN:x\nNOTE;ENCODING=QUOTED-PRINTABLE:abc=",
+			expected: []string{"FN:x", "NOTE;ENCODING=QUOTED-PRINTABLE:abc="},
+		},
+		{
+			name:     "equals without quoted-printable",
+			text:     "NOTE:a=\nFN:x\n",
+			expected: []string{"NOTE:a=", "FN:x"},
+		},
+		{
+			name:     "quoted-printable in value only",
+			text:     "NOTE:QUOTED-PRINTABLE=\nFN:x\n",
+			expected: []string{"NOTE:QUOTED-PRINTABLE=", "FN:x"},
+		},
+		{
+			name:     "continuation without previous line",
+			text:     " FN:x\n",
+			expected: []string{" FN:x"},
+		},
+		{
+			name:     "trailing backslash",
+			text:     "FN:a\\\n b\\\n",
+			expected: []string{"FN:a\\b\\"},
+		},
+		{
+			name:     "blank lines",
+			text:     "FN:x\n\n\nEND:VCARD",
+			expected: []string{"FN:x", "", "", "END:VCARD"},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual, err := unfoldVCardLines(strings.NewReader(testCase.text))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(testCase.expected, actual) {
+				t.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package rule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gogama/reee-evolution/daemon"
+	"github.com/gogama/reee-evolution/log"
+	"github.com/gogama/reee-evolution/protocol"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadDecl loads declarative rules from a YAML or JSON file. The
+// document has the same shape as the argument to the JavaScript
+// addRules() function, except that each rule has either a "when"
+// condition or a registered native rule kind instead of a rule
+// function.
+func (set *GroupSet) LoadDecl(ctx context.Context, logger log.Printer, path string) error {
+	start := time.Now()
+
+	text, err := loadFileText(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	var doc any
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal([]byte(text), &doc)
+	} else {
+		err = yaml.Unmarshal([]byte(text), &doc)
+	}
+	if err != nil {
+		return fmt.Errorf("reeed: can't parse %s: %s", path, err)
+	}
+
+	rm, err := declMap(doc, "")
+	if err != nil {
+		return fmt.Errorf("reeed: %s: %s", path, err)
+	}
+	groups := make([]string, 0, len(rm))
+	for g := range rm {
+		if err = protocol.ValidateName("group name", g); err != nil {
+			return fmt.Errorf("reeed: %s: %s", path, err)
+		}
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	var numRules int
+	for _, g := range groups {
+		group := set.group(g)
+		spec, err := unmarshalDeclGroupSpec(rm[g], g)
+		if err != nil {
+			return fmt.Errorf("reeed: %s: %s", path, err)
+		}
+		if spec.onError != nil {
+			err = group.setOnError(*spec.onError, path)
+			if err != nil {
+				return err
+			}
+		}
+		for i, r := range spec.rules {
+			var rule daemon.Rule
+			rule, err = compileDeclRule(r, fmt.Sprintf("%s[%d]", g, i), path)
+			if err != nil {
+				return fmt.Errorf("reeed: %s: %s", path, err)
+			}
+			err = group.addRule(rule, path)
+			if err != nil {
+				return err
+			}
+			numRules++
+		}
+	}
+
+	elapsed := time.Since(start)
+	log.Verbose(logger, "loaded %d groups and %d rules from %s in %s.", len(groups), numRules, path, elapsed)
+	return nil
+}
+
+type declGroupSpec struct {
+	onError *daemon.ErrorPolicy
+	rules   []any
+}
+
+func unmarshalDeclGroupSpec(v any, group string) (spec declGroupSpec, err error) {
+	if list, ok := v.([]any); ok {
+		spec.rules = list
+		return
+	}
+	m, err := declMap(v, group)
+	if err != nil {
+		return
+	}
+	for key, value := range m {
+		switch key {
+		case "onError":
+			var s string
+			s, err = declString(value, group+".onError")
+			if err != nil {
+				return
+			}
+			var p daemon.ErrorPolicy
+			err = p.UnmarshalText([]byte(s))
+			if err != nil {
+				err = fmt.Errorf("invalid onError for group %s: must be \"fail\" or \"skip\" but is %q", group, s)
+				return
+			}
+			spec.onError = &p
+		case "rules":
+			spec.rules, err = declList(value, group+".rules")
+			if err != nil {
+				return
+			}
+		default:
+			err = fmt.Errorf("unknown property %s for group %s", key, group)
+			return
+		}
+	}
+	return
+}
+
+type declRule struct {
+	name string
+	when condition
+}
+
+func (r *declRule) String() string {
+	return r.name
+}
+
+func (r *declRule) Eval(_ context.Context, _ log.Printer, msg *daemon.Message, tagger daemon.Tagger) (match bool, err error) {
+	return r.when(msg, tagger), nil
+}
+
+func compileDeclRule(v any, path, filePath string) (rule daemon.Rule, err error) {
+	m, err := declMap(v, path)
+	if err != nil {
+		return
+	}
+	var name string
+	var when condition
+	var native string
+	var params map[string]any
+	for key, value := range m {
+		switch key {
+		case "name":
+			name, err = declString(value, path+".name")
+		case "when":
+			when, err = compileCondition(value, path+".when")
+		case "native":
+			native, err = declString(value, path+".native")
+		case "params":
+			params, err = declMap(value, path+".params")
+		default:
+			err = fmt.Errorf("unknown property %s for rule %s", key, path)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if name == "" {
+		err = fmt.Errorf("can't determine rule name: rule %s", path)
+		return
+	} else if err = protocol.ValidateName("rule name", name); err != nil {
+		err = fmt.Errorf("%s: rule %s", err, path)
+		return
+	} else if native != "" && when != nil {
+		err = fmt.Errorf("rule %s has both a condition and a native kind", path)
+		return
+	} else if native != "" {
+		return newNativeRule(native, name, newParams(params, filePath))
+	} else if when == nil {
+		err = fmt.Errorf("missing condition: rule %s", path)
+		return
+	}
+	rule = &declRule{
+		name: name,
+		when: when,
+	}
+	return
+}
+
+func declMap(v any, path string) (map[string]any, error) {
+	if m, ok := v.(map[string]any); ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("expected an object at %s, but found %s", declPathOrRoot(path), declTypeOf(v))
+}
+
+func declList(v any, path string) ([]any, error) {
+	if list, ok := v.([]any); ok {
+		return list, nil
+	}
+	return nil, fmt.Errorf("expected an array at %s, but found %s", declPathOrRoot(path), declTypeOf(v))
+}
+
+func declString(v any, path string) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("expected a string at %s, but found %s", declPathOrRoot(path), declTypeOf(v))
+}
+
+func declPathOrRoot(path string) string {
+	if path == "" {
+		return "document root"
+	}
+	return path
+}
+
+func declTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	default:
+		return fmt.Sprintf("a %T", v)
+	}
+}
+
+/**
+inbox:
+  onError: skip
+  rules:
+    - name: acme-invoices
+      when:
+        all:
+          - from: {domain: acme.com}
+          - subject: {regex: "(?i)invoice"}
+          - attachment: {fileName: {suffix: .pdf}}
+newsletters:
+  - name: known-senders
+    native: sender-in-file
+    params: {path: newsletters.txt}
+  - name: lists
+    when:
+      any:
+        - header: {name: List-Id}
+        - tag: {key: newsletter, value: "true"}
+*/
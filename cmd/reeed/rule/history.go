@@ -0,0 +1,173 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/gogama/reee-evolution/daemon"
+)
+
+// installHistoryFunc makes read-only queries about previously seen
+// messages available to JavaScript as reee.history(msg). The message
+// being evaluated is never counted. History is bound to the evaluation
+// of msg.
+func installHistoryFunc(cont *vmContainer, reeeObject *goja.Object) error {
+	vm := cont.vm
+	return reeeObject.Set("history", vm.ToValue(func(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+		m, ok := call.Argument(0).Export().(*jsMessage)
+		if !ok {
+			throwJSException(vm, errUnexpectedArgType(0, m, call.Argument(0).Export()))
+		}
+		v, err := marshalHistory(cont, m)
+		if err != nil {
+			throwJSException(vm, err)
+		}
+		return v
+	}))
+}
+
+type jsHistory struct {
+	history daemon.History
+}
+
+func marshalHistory(cont *vmContainer, m *jsMessage) (goja.Value, error) {
+	if m.history != nil {
+		return m.history, nil
+	}
+	history, ok := m.evalCtx.(daemon.History)
+	if !ok {
+		return nil, errors.New("reeed: history() may only be called while evaluating a rule")
+	}
+	if cont.historyProto == nil {
+		proto, err := jsHistoryPrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.historyProto = proto
+	}
+	h := &jsHistory{
+		history: history,
+	}
+	o := cont.vm.ToValue(h).ToObject(cont.vm)
+	err := o.SetPrototype(cont.historyProto)
+	if err != nil {
+		return nil, err
+	}
+	m.history = o
+	return o, nil
+}
+
+func jsHistoryPrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	methods := []struct {
+		name string
+		f    func(call goja.FunctionCall, vm *goja.Runtime) goja.Value
+	}{
+		{"countFrom", jsHistoryCountFrom},
+		{"firstSeen", jsHistoryFirstSeen},
+		{"lastTags", jsHistoryLastTags},
+		{"domainVolume", jsHistoryDomainVolume},
+	}
+	for _, m := range methods {
+		err := proto.Set(m.name, vm.ToValue(m.f))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proto, nil
+}
+
+func jsHistoryThis(call goja.FunctionCall, vm *goja.Runtime) daemon.History {
+	h, ok := call.This.Export().(*jsHistory)
+	if !ok {
+		throwJSException(vm, errUnexpectedThisType(h, call.This.Export()))
+	}
+	return h.history
+}
+
+func checkHistory(vm *goja.Runtime, method string, err error) {
+	if err != nil {
+		throwJSException(vm, fmt.Sprintf("reeed: history.%s() failed: %s", method, err))
+	}
+}
+
+func jsHistoryCountFrom(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	history := jsHistoryThis(call, vm)
+	since := unmarshalSince(vm, "countFrom", call.Argument(1))
+	n, err := history.CountFrom(call.Argument(0).String(), since)
+	checkHistory(vm, "countFrom", err)
+	return vm.ToValue(n)
+}
+
+func jsHistoryFirstSeen(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	t, hit, err := jsHistoryThis(call, vm).FirstSeen(call.Argument(0).String())
+	checkHistory(vm, "firstSeen", err)
+	if !hit {
+		return goja.Null()
+	}
+	v, err := marshalDate(vm, t)
+	checkHistory(vm, "firstSeen", err)
+	return v
+}
+
+func jsHistoryLastTags(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	tags, hit, err := jsHistoryThis(call, vm).LastTags(call.Argument(0).String())
+	checkHistory(vm, "lastTags", err)
+	if !hit {
+		return goja.Null()
+	}
+	o := vm.NewObject()
+	for k, v := range tags {
+		_ = o.Set(k, v)
+	}
+	return o
+}
+
+// jsHistoryDomainVolume implements domainVolume(domain, window). The
+// window is the number of milliseconds before now to count messages
+// over. As with countFrom, it may instead be a Date to count from, and
+// a missing window means all of history.
+func jsHistoryDomainVolume(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	history := jsHistoryThis(call, vm)
+	since := unmarshalWindow(vm, "domainVolume", call.Argument(1))
+	n, err := history.DomainVolume(call.Argument(0).String(), since)
+	checkHistory(vm, "domainVolume", err)
+	return vm.ToValue(n)
+}
+
+// unmarshalSince converts an optional Date, or a time in milliseconds
+// since the Unix epoch, to a time. A missing time means the beginning of
+// history.
+func unmarshalSince(vm *goja.Runtime, method string, v goja.Value) time.Time {
+	if goja.IsUndefined(v) || goja.IsNull(v) {
+		return time.Time{}
+	}
+	if t, ok := v.Export().(time.Time); ok {
+		return t
+	}
+	ms := v.ToFloat()
+	if math.IsNaN(ms) || math.IsInf(ms, 0) {
+		throwJSException(vm, fmt.Sprintf("reeed: history.%s() invalid time: %s", method, v))
+	}
+	return time.UnixMilli(int64(ms))
+}
+
+// unmarshalWindow converts an optional Date, or a window in milliseconds
+// before now, to the time the window starts. A missing window means the
+// beginning of history.
+func unmarshalWindow(vm *goja.Runtime, method string, v goja.Value) time.Time {
+	if goja.IsUndefined(v) || goja.IsNull(v) {
+		return time.Time{}
+	}
+	if t, ok := v.Export().(time.Time); ok {
+		return t
+	}
+	ms := v.ToFloat()
+	if math.IsNaN(ms) || math.IsInf(ms, 0) || ms < 0 {
+		throwJSException(vm, fmt.Sprintf("reeed: history.%s() invalid window: %s", method, v))
+	}
+	return time.Now().Add(-time.Duration(ms * float64(time.Millisecond)))
+}
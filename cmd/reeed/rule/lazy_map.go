@@ -1,6 +1,8 @@
 package rule
 
 import (
+	"sort"
+
 	"github.com/dop251/goja"
 	"github.com/gogama/reee-evolution/daemon"
 	"github.com/jhillyerd/enmime"
@@ -222,3 +224,20 @@ func (tm tagsMap) set(key, value string) {
 func (tm tagsMap) deleteKey(key string) {
 	tm.DeleteTag(key)
 }
+
+// storedTagsMap is the read-only tags of a stored message.
+type storedTagsMap map[string]string
+
+func (sm storedTagsMap) keys() []string {
+	keys := make([]string, 0, len(sm))
+	for key := range sm {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (sm storedTagsMap) get(key string) (string, bool) {
+	value, ok := sm[key]
+	return value, ok
+}
@@ -0,0 +1,248 @@
+package rule
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/jhillyerd/enmime"
+	"golang.org/x/net/html"
+)
+
+// linksMax bounds the number of links extracted from one message.
+const linksMax = 1000
+
+// link is a hyperlink found in the HTML or text body of a message.
+type link struct {
+	url       string // href of an anchor, or URL found in text
+	text      string // Display text of an anchor, with whitespace collapsed
+	source    string // "html" or "text"
+	scheme    string // Lower-cased URL scheme, e.g. "https"
+	host      string // Lower-cased host name or IP, without port
+	textHost  string // Host of the display text, if it looks like a URL
+	mismatch  bool   // Whether textHost differs from host
+	shortener bool   // Whether host is a known link shortener
+	ipHost    bool   // Whether host is an IP address literal
+}
+
+// linkShorteners are the host names of well-known link shortening
+// services.
+var linkShorteners = map[string]bool{
+	"adf.ly":      true,
+	"bit.ly":      true,
+	"bitly.com":   true,
+	"bl.ink":      true,
+	"buff.ly":     true,
+	"cutt.ly":     true,
+	"goo.gl":      true,
+	"is.gd":       true,
+	"lnkd.in":     true,
+	"ow.ly":       true,
+	"rb.gy":       true,
+	"rebrand.ly":  true,
+	"s.id":        true,
+	"shorturl.at": true,
+	"t.co":        true,
+	"t.ly":        true,
+	"tiny.cc":     true,
+	"tinyurl.com": true,
+	"v.gd":        true,
+	"x.co":        true,
+}
+
+var (
+	textURLRegexp     = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+	urlLikeTextRegexp = regexp.MustCompile(`(?i)^(?:[a-z][a-z0-9+.-]*://)?[a-z0-9-]+(?:\.[a-z0-9-]+)+\.?(?::\d+)?(?:[/?#]\S*)?$`)
+)
+
+// parseLinks extracts the links from the HTML body of the envelope,
+// followed by the bare URLs in its text body.
+func parseLinks(e *enmime.Envelope) []link {
+	links := []link{}
+	if e.HTML != "" {
+		if doc, err := html.Parse(strings.NewReader(e.HTML)); err == nil {
+			links = appendHTMLLinks(links, doc)
+		}
+	}
+	for _, u := range textURLRegexp.FindAllString(e.Text, -1) {
+		if len(links) >= linksMax {
+			break
+		}
+		u = strings.TrimRight(u, `.,;:!?)]}`)
+		links = append(links, newLink(u, "", "text"))
+	}
+	return links
+}
+
+func appendHTMLLinks(links []link, n *html.Node) []link {
+	if len(links) >= linksMax {
+		return links
+	}
+	if n.Type == html.ElementNode && (n.Data == "a" || n.Data == "area") {
+		if href := strings.TrimSpace(htmlAttr(n, "href")); href != "" && !strings.HasPrefix(href, "#") {
+			links = append(links, newLink(href, strings.Join(strings.Fields(htmlText(n)), " "), "html"))
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		links = appendHTMLLinks(links, c)
+	}
+	return links
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && strings.EqualFold(attr.Key, key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func htmlText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+func newLink(rawURL, text, source string) link {
+	l := link{
+		url:    rawURL,
+		text:   text,
+		source: source,
+	}
+	l.scheme, l.host = urlSchemeAndHost(rawURL)
+	l.shortener = linkShorteners[strings.TrimPrefix(l.host, "www.")]
+	l.ipHost = isIPHost(l.host)
+	if text != "" && urlLikeTextRegexp.MatchString(text) {
+		_, l.textHost = urlSchemeAndHost(text)
+		l.mismatch = l.textHost != "" && l.host != "" &&
+			strings.TrimPrefix(l.textHost, "www.") != strings.TrimPrefix(l.host, "www.")
+	}
+	return l
+}
+
+// urlSchemeAndHost returns the lower-cased scheme and host of a URL. A
+// URL without a scheme, such as "www.example.com/x", is treated as an
+// HTTP URL.
+func urlSchemeAndHost(rawURL string) (scheme, host string) {
+	if !strings.Contains(rawURL, "://") {
+		// Distinguish "mailto:x@example.com" from "example.com:8080".
+		i := strings.IndexByte(rawURL, ':')
+		if i < 0 || (i+1 < len(rawURL) && rawURL[i+1] >= '0' && rawURL[i+1] <= '9') {
+			rawURL = "http://" + rawURL
+		}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", ""
+	}
+	return strings.ToLower(u.Scheme), strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// isIPHost reports whether a host is an IP address, including the
+// dotless decimal and hexadecimal forms browsers accept for IPv4.
+func isIPHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	_, err := strconv.ParseUint(host, 0, 32)
+	return err == nil
+}
+
+type jsLink struct {
+	l *link
+
+	url       goja.Value // string
+	text      goja.Value // string
+	source    goja.Value // string
+	scheme    goja.Value // string
+	host      goja.Value // string
+	textHost  goja.Value // string
+	mismatch  goja.Value // boolean
+	shortener goja.Value // boolean
+	ipHost    goja.Value // boolean
+}
+
+func marshalLinks(cont *vmContainer, links []link) (goja.Value, error) {
+	if cont.linkProto == nil {
+		proto, err := jsLinkPrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.linkProto = proto
+	}
+	a := make([]goja.Value, len(links))
+	for i := range links {
+		l := &jsLink{
+			l: &links[i],
+		}
+		o := cont.vm.ToValue(l).ToObject(cont.vm)
+		err := o.SetPrototype(cont.linkProto)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = o
+	}
+	return cont.vm.ToValue(a), nil
+}
+
+func jsLinkPrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	stringProps := []struct {
+		propName string
+		get      func(*link) string
+	}{
+		{"url", func(l *link) string { return l.url }},
+		{"text", func(l *link) string { return l.text }},
+		{"source", func(l *link) string { return l.source }},
+		{"scheme", func(l *link) string { return l.scheme }},
+		{"host", func(l *link) string { return l.host }},
+		{"textHost", func(l *link) string { return l.textHost }},
+	}
+	for _, prop := range stringProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(l *jsLink) (goja.Value, error) {
+			if value := get(l.l); value != "" {
+				return vm.ToValue(value), nil
+			}
+			return goja.Null(), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	boolProps := []struct {
+		propName string
+		get      func(*link) bool
+	}{
+		{"mismatch", func(l *link) bool { return l.mismatch }},
+		{"shortener", func(l *link) bool { return l.shortener }},
+		{"ipHost", func(l *link) bool { return l.ipHost }},
+	}
+	for _, prop := range boolProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(l *jsLink) (goja.Value, error) {
+			return vm.ToValue(get(l.l)), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proto, nil
+}
@@ -0,0 +1,184 @@
+package rule
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/jhillyerd/enmime"
+)
+
+// mailingList is the mailing list metadata of a message, parsed from
+// the RFC 2369 and RFC 2919 List-* headers and the Precedence header.
+type mailingList struct {
+	id                string // List-Id identifier, without angle brackets
+	name              string // List-Id phrase, if any
+	unsubscribe       []string
+	unsubscribeMailto []string
+	unsubscribeHTTPS  []string
+	unsubscribePost   string
+	post              []string
+	archive           []string
+	precedence        string
+}
+
+var listURLRegexp = regexp.MustCompile(`<([^>]*)>`)
+
+// parseMailingList returns the mailing list metadata of the envelope,
+// or nil if the message has no list headers.
+func parseMailingList(e *enmime.Envelope) *mailingList {
+	list := &mailingList{
+		unsubscribe:       listURLs(e.GetHeader("List-Unsubscribe")),
+		unsubscribeMailto: []string{},
+		unsubscribeHTTPS:  []string{},
+		unsubscribePost:   strings.TrimSpace(e.GetHeader("List-Unsubscribe-Post")),
+		post:              listURLs(e.GetHeader("List-Post")),
+		archive:           listURLs(e.GetHeader("List-Archive")),
+		precedence:        strings.ToLower(strings.TrimSpace(e.GetHeader("Precedence"))),
+	}
+	if listID := strings.TrimSpace(e.GetHeader("List-Id")); listID != "" {
+		if m := listURLRegexp.FindStringSubmatchIndex(listID); m != nil {
+			list.id = strings.ToLower(strings.TrimSpace(listID[m[2]:m[3]]))
+			list.name = strings.Trim(strings.TrimSpace(listID[:m[0]]), `"`)
+		} else {
+			list.id = strings.ToLower(listID)
+		}
+	}
+	for _, u := range list.unsubscribe {
+		lower := strings.ToLower(u)
+		if strings.HasPrefix(lower, "mailto:") {
+			list.unsubscribeMailto = append(list.unsubscribeMailto, u)
+		} else if strings.HasPrefix(lower, "https:") {
+			list.unsubscribeHTTPS = append(list.unsubscribeHTTPS, u)
+		}
+	}
+	if list.id == "" && len(list.unsubscribe) == 0 && list.unsubscribePost == "" &&
+		len(list.post) == 0 && len(list.archive) == 0 && list.precedence == "" {
+		return nil
+	}
+	return list
+}
+
+// listURLs returns the angle-bracketed URLs in a List-* header value.
+func listURLs(value string) []string {
+	urls := []string{}
+	for _, m := range listURLRegexp.FindAllStringSubmatch(value, -1) {
+		if u := strings.Join(strings.Fields(m[1]), ""); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// oneClick reports whether the list supports RFC 8058 one-click
+// unsubscribe, which requires both the List-Unsubscribe-Post header and
+// an HTTPS unsubscribe URL.
+func (list *mailingList) oneClick() bool {
+	return strings.EqualFold(list.unsubscribePost, "List-Unsubscribe=One-Click") && len(list.unsubscribeHTTPS) > 0
+}
+
+// bulk reports whether the Precedence header marks the message as bulk
+// mail.
+func (list *mailingList) bulk() bool {
+	switch list.precedence {
+	case "bulk", "list", "junk":
+		return true
+	default:
+		return false
+	}
+}
+
+type jsMailingList struct {
+	list *mailingList
+
+	id                goja.Value // string
+	name              goja.Value // string
+	unsubscribe       goja.Value // []string
+	unsubscribeMailto goja.Value // []string
+	unsubscribeHttps  goja.Value // []string
+	unsubscribePost   goja.Value // string
+	oneClick          goja.Value // boolean
+	post              goja.Value // []string
+	archive           goja.Value // []string
+	precedence        goja.Value // string
+	bulk              goja.Value // boolean
+}
+
+func marshalMailingList(cont *vmContainer, list *mailingList) (goja.Value, error) {
+	if list == nil {
+		return goja.Null(), nil
+	}
+	if cont.mailingListProto == nil {
+		proto, err := jsMailingListPrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.mailingListProto = proto
+	}
+	l := &jsMailingList{
+		list: list,
+	}
+	o := cont.vm.ToValue(l).ToObject(cont.vm)
+	err := o.SetPrototype(cont.mailingListProto)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func jsMailingListPrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	stringProps := []struct {
+		propName string
+		get      func(*mailingList) string
+	}{
+		{"id", func(list *mailingList) string { return list.id }},
+		{"name", func(list *mailingList) string { return list.name }},
+		{"unsubscribePost", func(list *mailingList) string { return list.unsubscribePost }},
+		{"precedence", func(list *mailingList) string { return list.precedence }},
+	}
+	for _, prop := range stringProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(l *jsMailingList) (goja.Value, error) {
+			if value := get(l.list); value != "" {
+				return vm.ToValue(value), nil
+			}
+			return goja.Null(), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	listProps := []struct {
+		propName string
+		get      func(*mailingList) []string
+	}{
+		{"unsubscribe", func(list *mailingList) []string { return list.unsubscribe }},
+		{"unsubscribeMailto", func(list *mailingList) []string { return list.unsubscribeMailto }},
+		{"unsubscribeHttps", func(list *mailingList) []string { return list.unsubscribeHTTPS }},
+		{"post", func(list *mailingList) []string { return list.post }},
+		{"archive", func(list *mailingList) []string { return list.archive }},
+	}
+	for _, prop := range listProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(l *jsMailingList) (goja.Value, error) {
+			return vm.ToValue(get(l.list)), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := defineCachedProperty(vm, proto, "oneClick", func(l *jsMailingList) (goja.Value, error) {
+		return vm.ToValue(l.list.oneClick()), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "bulk", func(l *jsMailingList) (goja.Value, error) {
+		return vm.ToValue(l.list.bulk()), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
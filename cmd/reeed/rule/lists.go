@@ -0,0 +1,334 @@
+package rule
+
+import (
+	"bufio"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/gogama/reee-evolution/log"
+)
+
+// Lists is a set of named address and domain lists loaded from the
+// list files in a directory, which are reloaded when they change. The
+// name of a list is the path of its file relative to the directory,
+// without the extension.
+//
+// Each line of a list file is an entry, and everything after a '#' is a
+// comment. An entry is an address (user@example.com), a domain
+// (example.com or @example.com), or a wildcard domain (*.example.com)
+// which matches the subdomains of a domain but not the domain itself.
+type Lists struct {
+	r *reloader[map[string]*addressList]
+}
+
+// listExts are the extensions of list files.
+var listExts = []string{".txt", ".list"}
+
+// NewLists loads the list files in a directory. The directory is
+// searched recursively for files having the extension .txt or .list.
+func NewLists(logger log.Printer, dir string) (*Lists, error) {
+	r, err := newReloader("lists", []string{dir}, listExts, logger, func(files []string) (map[string]*addressList, error) {
+		return loadAddressLists(dir, files)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reeed: failed to load lists: %w", err)
+	}
+	return &Lists{r: r}, nil
+}
+
+func (l *Lists) get() map[string]*addressList {
+	if l == nil {
+		return nil
+	}
+	return l.r.get()
+}
+
+type addressList struct {
+	name      string
+	size      int
+	addresses map[string]struct{}
+	domains   map[string]struct{}
+	suffixes  map[string]struct{} // Domains whose subdomains match
+}
+
+func loadAddressLists(dir string, files []string) (map[string]*addressList, error) {
+	lists := make(map[string]*addressList, len(files))
+	for _, file := range files {
+		name := file
+		if rel, err := filepath.Rel(dir, file); err == nil {
+			name = rel
+		}
+		name = filepath.ToSlash(strings.TrimSuffix(name, filepath.Ext(name)))
+		if _, ok := lists[name]; ok {
+			return nil, fmt.Errorf("duplicate list name %q: %s", name, file)
+		}
+		list, err := loadAddressList(name, file)
+		if err != nil {
+			return nil, err
+		}
+		lists[name] = list
+	}
+	return lists, nil
+}
+
+func loadAddressList(name, file string) (*addressList, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	list := &addressList{
+		name:      name,
+		addresses: make(map[string]struct{}),
+		domains:   make(map[string]struct{}),
+		suffixes:  make(map[string]struct{}),
+	}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		entry := scanner.Text()
+		if i := strings.IndexByte(entry, '#'); i >= 0 {
+			entry = entry[:i]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err = list.add(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	list.size = len(list.addresses) + len(list.domains) + len(list.suffixes)
+	return list, nil
+}
+
+func (list *addressList) add(entry string) error {
+	if strings.ContainsAny(entry, " \t<>") {
+		return fmt.Errorf("invalid entry %q", entry)
+	}
+	entry = strings.TrimPrefix(entry, "*@")
+	entry = strings.TrimPrefix(entry, "@")
+	if strings.HasPrefix(entry, "*.") {
+		entry = entry[2:]
+		if entry == "" || strings.ContainsAny(entry, "*@") {
+			return fmt.Errorf("invalid wildcard entry %q", entry)
+		}
+		list.suffixes[asciiDomain(entry)] = struct{}{}
+		return nil
+	}
+	if strings.ContainsRune(entry, '*') {
+		return fmt.Errorf("unsupported wildcard in entry %q", entry)
+	}
+	if i := strings.LastIndexByte(entry, '@'); i >= 0 {
+		list.addresses[normalizeAddress(entry[:i], entry[i+1:])] = struct{}{}
+		return nil
+	}
+	list.domains[asciiDomain(entry)] = struct{}{}
+	return nil
+}
+
+// contains reports whether an address or a domain is in the list.
+func (list *addressList) contains(s string) bool {
+	domain := s
+	if i := strings.LastIndexByte(s, '@'); i >= 0 {
+		if _, ok := list.addresses[normalizeAddress(s[:i], s[i+1:])]; ok {
+			return true
+		}
+		domain = s[i+1:]
+	}
+	domain = asciiDomain(domain)
+	if domain == "" {
+		return false
+	}
+	if _, ok := list.domains[domain]; ok {
+		return true
+	}
+	for i := strings.IndexByte(domain, '.'); i >= 0; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		if _, ok := list.suffixes[domain]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeAddress(localPart, domain string) string {
+	return strings.ToLower(localPart) + "@" + asciiDomain(domain)
+}
+
+// installListsObject makes the lists available to JavaScript as
+// reee.lists. Because the lists are reloaded when their files change,
+// reee.lists is a dynamic object which always reflects the current
+// lists.
+func installListsObject(set *GroupSet, cont *vmContainer, reeeObject *goja.Object) error {
+	lo := &jsLists{
+		lists: set.Lists,
+		cont:  cont,
+		cache: make(map[string]goja.Value),
+	}
+	return reeeObject.Set("lists", cont.vm.NewDynamicObject(lo))
+}
+
+// jsLists implements goja.DynamicObject for reee.lists.
+type jsLists struct {
+	lists *Lists
+	cont  *vmContainer
+	cache map[string]goja.Value
+}
+
+func (lo *jsLists) Get(key string) goja.Value {
+	if _, ok := lo.lists.get()[key]; !ok {
+		return nil
+	}
+	if v, ok := lo.cache[key]; ok {
+		return v
+	}
+	v, err := marshalAddressList(lo.cont, lo.lists, key)
+	if err != nil {
+		throwJSException(lo.cont.vm, err)
+	}
+	lo.cache[key] = v
+	return v
+}
+
+func (lo *jsLists) Set(_ string, _ goja.Value) bool {
+	return false
+}
+
+func (lo *jsLists) Has(key string) bool {
+	_, ok := lo.lists.get()[key]
+	return ok
+}
+
+func (lo *jsLists) Delete(_ string) bool {
+	return false
+}
+
+func (lo *jsLists) Keys() []string {
+	lists := lo.lists.get()
+	keys := make([]string, 0, len(lists))
+	for key := range lists {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsAddressList is a list by name. The list is looked up on each use,
+// so a list object held by a rule sees the list as it is after its
+// file is reloaded.
+type jsAddressList struct {
+	lists *Lists
+
+	name goja.Value // string
+}
+
+func marshalAddressList(cont *vmContainer, lists *Lists, name string) (goja.Value, error) {
+	if cont.addressListProto == nil {
+		proto, err := jsAddressListPrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.addressListProto = proto
+	}
+	l := &jsAddressList{
+		lists: lists,
+		name:  cont.vm.ToValue(name),
+	}
+	o := cont.vm.ToValue(l).ToObject(cont.vm)
+	err := o.SetPrototype(cont.addressListProto)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// list returns the current list, or nil if its file has been removed.
+func (l *jsAddressList) list() *addressList {
+	return l.lists.get()[l.name.String()]
+}
+
+func jsAddressListPrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	err := defineGetterProperty(vm, proto, "name", func(_ *goja.Runtime, this any) (goja.Value, error) {
+		if this, ok := this.(*jsAddressList); ok {
+			return this.name, nil
+		}
+		return nil, errUnexpectedThisType(&jsAddressList{}, this)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineGetterProperty(vm, proto, "size", func(vm *goja.Runtime, this any) (goja.Value, error) {
+		if this, ok := this.(*jsAddressList); ok {
+			if list := this.list(); list != nil {
+				return vm.ToValue(list.size), nil
+			}
+			return vm.ToValue(0), nil
+		}
+		return nil, errUnexpectedThisType(&jsAddressList{}, this)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = proto.Set("contains", vm.ToValue(jsAddressListContains))
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
+
+// jsAddressListContains implements contains(x), where x is a mailbox,
+// an address or a domain, or an array of them. The result is true if
+// any of them is in the list.
+func jsAddressListContains(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	l, ok := call.This.Export().(*jsAddressList)
+	if !ok {
+		throwJSException(vm, errUnexpectedThisType(l, call.This.Export()))
+	}
+	list := l.list()
+	if list == nil {
+		return vm.ToValue(false)
+	}
+	var contains func(v any) bool
+	contains = func(v any) bool {
+		switch x := v.(type) {
+		case nil:
+			return false
+		case *jsMailbox:
+			return list.contains(x.mailbox.Address)
+		case string:
+			if addr, err := mail.ParseAddress(x); err == nil {
+				x = addr.Address
+			}
+			return list.contains(strings.TrimSpace(x))
+		case []any:
+			for _, y := range x {
+				if contains(y) {
+					return true
+				}
+			}
+			return false
+		case []goja.Value:
+			for _, y := range x {
+				if contains(y.Export()) {
+					return true
+				}
+			}
+			return false
+		default:
+			throwJSException(vm, errUnexpectedArgType(0, "", v))
+			return false
+		}
+	}
+	return vm.ToValue(contains(call.Argument(0).Export()))
+}
@@ -13,11 +13,28 @@ import (
 	"github.com/dop251/goja"
 	"github.com/gogama/reee-evolution/daemon"
 	"github.com/gogama/reee-evolution/log"
+	"github.com/gogama/reee-evolution/protocol"
+	"github.com/tetratelabs/wazero"
 )
 
 type GroupSet struct {
-	groups map[string]*jsGroup
+	// Calendar, if not nil, is consulted by reee.calendar.conflicts().
+	// It must be set before any rules are loaded.
+	Calendar *BusyCalendar
+	// Lists, if not nil, are exposed as reee.lists. They must be set
+	// before any rules are loaded.
+	Lists *Lists
+	// Contacts, if not nil, is the address book consulted by
+	// reee.contacts and by the isContact property of mailboxes. It must
+	// be set before any rules are loaded.
+	Contacts *Contacts
+	// Dir is the rule directory. The rule state namespace of a rule
+	// file is its path relative to Dir.
+	Dir string
+
+	groups map[string]*group
 	vms    []*vmContainer
+	wasm   wazero.Runtime
 }
 
 func (set *GroupSet) Load(ctx context.Context, logger log.Printer, path string, randSeed int64) error {
@@ -40,12 +57,16 @@ func (set *GroupSet) Load(ctx context.Context, logger log.Printer, path string,
 	}
 	vm.SetFieldNameMapper(goja.UncapFieldNameMapper())
 	cont := &vmContainer{
-		path: path,
-		id:   len(set.vms),
-		vm:   vm,
+		path:     path,
+		id:       len(set.vms),
+		vm:       vm,
+		contacts: set.Contacts,
 	}
 	set.vms = append(set.vms, cont)
-	hc := installAddRuleHook(set, cont)
+	hc, err := installAddRuleHook(set, cont)
+	if err != nil {
+		return err
+	}
 
 	// TODO: Include context.
 	_, err = vm.RunProgram(program)
@@ -59,18 +80,32 @@ func (set *GroupSet) Load(ctx context.Context, logger log.Printer, path string,
 	return nil
 }
 
-func (set *GroupSet) ToMap() map[string][]daemon.Rule {
-	m := make(map[string][]daemon.Rule, len(set.groups))
+func (set *GroupSet) ToMap() map[string]daemon.Group {
+	m := make(map[string]daemon.Group, len(set.groups))
 	for _, g := range set.groups {
 		rules := make([]daemon.Rule, len(g.rules))
-		for i := range g.rules {
-			rules[i] = g.rules[i]
+		copy(rules, g.rules)
+		m[g.name] = daemon.Group{
+			Rules:   rules,
+			OnError: g.onError,
 		}
-		m[g.name] = rules
 	}
 	return m
 }
 
+// Close releases the resources held by the loaded rules, such as the
+// WebAssembly runtime. The rules must not be evaluated after Close, so
+// when the rules are reloaded, the old GroupSet should be closed once
+// the new one has replaced it.
+func (set *GroupSet) Close(ctx context.Context) error {
+	if set.wasm == nil {
+		return nil
+	}
+	err := set.wasm.Close(ctx)
+	set.wasm = nil
+	return err
+}
+
 func loadFileText(ctx context.Context, path string) (string, error) {
 	ch := make(chan struct{})
 	var b []byte
@@ -96,7 +131,7 @@ type jsHookContainer struct {
 	numRules int
 }
 
-func installAddRuleHook(set *GroupSet, cont *vmContainer) *jsHookContainer {
+func installAddRuleHook(set *GroupSet, cont *vmContainer) (*jsHookContainer, error) {
 	hc := &jsHookContainer{
 		groups: make(map[string]bool),
 	}
@@ -120,36 +155,35 @@ func installAddRuleHook(set *GroupSet, cont *vmContainer) *jsHookContainer {
 		}
 		groups := make([]string, 0, len(rm))
 		for g := range rm {
-			// TODO: Validation on the group names please.
+			if err = protocol.ValidateName("group name", g); err != nil {
+				throwJSException(vm, fmt.Sprintf("reeed: %s", err))
+			}
 			groups = append(groups, g)
 		}
 		sort.Strings(groups)
-		if set.groups == nil {
-			set.groups = make(map[string]*jsGroup)
-		}
 		for _, g := range groups {
 			hc.groups[g] = true
-			var group *jsGroup
-			if group = set.groups[g]; group == nil {
-				group = &jsGroup{
-					parent:      set,
-					name:        g,
-					rulesByName: make(map[string]*jsRule),
+			group := set.group(g)
+			spec, err := unmarshalGroupSpec(vm, g, rm[g])
+			if err != nil {
+				throwJSException(vm, err)
+			}
+			if spec.onError != nil {
+				err = group.setOnError(*spec.onError, cont.path)
+				if err != nil {
+					throwJSException(vm, err)
 				}
-				set.groups[g] = group
 			}
-			ruleCandidates := rm[g]
-			for i, r := range ruleCandidates {
-				var rule *jsRule
+			for i, r := range spec.rules {
+				var rule daemon.Rule
 				rule, err = unmarshalRule(vm, r, cont, i, group)
 				if err != nil {
 					throwJSException(vm, err)
 				}
-				if group.rulesByName[rule.name] != nil {
-					throwJSException(vm, fmt.Sprintf("duplicate rule name %s in group %s (%s)", group.name, rule.name, cont.path))
+				err = group.addRule(rule, cont.path)
+				if err != nil {
+					throwJSException(vm, err)
 				}
-				group.rulesByName[rule.name] = rule
-				group.rules = append(group.rules, rule)
 				hc.numRules++
 			}
 		}
@@ -157,13 +191,40 @@ func installAddRuleHook(set *GroupSet, cont *vmContainer) *jsHookContainer {
 	}
 	// Make the hook function available in the JavaScript runtime.
 	reeeObject := cont.vm.NewObject()
-	reeeObject.Set("addRules", cont.vm.ToValue(hookFunc))
-	err := cont.vm.Set("reee", reeeObject)
+	err := reeeObject.Set("addRules", cont.vm.ToValue(hookFunc))
 	if err != nil {
-		// FIXME: Handle this error.
+		return nil, err
+	}
+	err = installCalendarObject(set, cont, reeeObject)
+	if err != nil {
+		return nil, err
+	}
+	err = installStateFunc(set, cont, reeeObject)
+	if err != nil {
+		return nil, err
+	}
+	err = installHistoryFunc(cont, reeeObject)
+	if err != nil {
+		return nil, err
+	}
+	err = installListsObject(set, cont, reeeObject)
+	if err != nil {
+		return nil, err
+	}
+	err = installContactsObject(cont, reeeObject)
+	if err != nil {
+		return nil, err
+	}
+	err = installClassifierFunc(cont, reeeObject)
+	if err != nil {
+		return nil, err
+	}
+	err = cont.vm.Set("reee", reeeObject)
+	if err != nil {
+		return nil, err
 	}
 	// Return the hook container.
-	return hc
+	return hc, nil
 }
 
 type vmContainer struct {
@@ -171,15 +232,35 @@ type vmContainer struct {
 	id                    int
 	vm                    *goja.Runtime
 	mu                    sync.Mutex
+	contacts              *Contacts // Address book, if any
 	msgProto              *goja.Object
 	loggerProto           *goja.Object
 	mailboxProto          *goja.Object
 	headersProto          *goja.Object
 	attachmentProto       *goja.Object
+	zipEntryProto         *goja.Object
 	tagsProto             *goja.Object
 	calendarProto         *goja.Object
 	calendarEventProto    *goja.Object
 	calendarAttendeeProto *goja.Object
+	partProto             *goja.Object
+	partHeadersProto      *goja.Object
+	authProto             *goja.Object
+	authResultProto       *goja.Object
+	hopProto              *goja.Object
+	mailingListProto      *goja.Object
+	linkProto             *goja.Object
+	remoteContentProto    *goja.Object
+	remoteResourceProto   *goja.Object
+	inlineRefProto        *goja.Object
+	bodyProto             *goja.Object
+	storedMessageProto    *goja.Object
+	storedTagsProto       *goja.Object
+	addressListProto      *goja.Object
+	contactProto          *goja.Object
+	classifierProto       *goja.Object
+	stateProto            *goja.Object
+	historyProto          *goja.Object
 }
 
 func (cont *vmContainer) acquire(ctx context.Context) error {
@@ -191,14 +272,101 @@ func (cont *vmContainer) release() {
 	// TODO
 }
 
-type jsGroup struct {
+type group struct {
 	parent      *GroupSet
-	rules       []*jsRule
-	rulesByName map[string]*jsRule
+	rules       []daemon.Rule
+	rulesByName map[string]daemon.Rule
 	name        string
+	onError     daemon.ErrorPolicy
+	onErrorPath string
+}
+
+func (set *GroupSet) group(name string) *group {
+	if set.groups == nil {
+		set.groups = make(map[string]*group)
+	}
+	g := set.groups[name]
+	if g == nil {
+		g = &group{
+			parent:      set,
+			name:        name,
+			rulesByName: make(map[string]daemon.Rule),
+		}
+		set.groups[name] = g
+	}
+	return g
+}
+
+func (g *group) addRule(r daemon.Rule, path string) error {
+	name := r.String()
+	if g.rulesByName[name] != nil {
+		return fmt.Errorf("reeed: duplicate rule name %s in group %s (%s)", name, g.name, path)
+	}
+	g.rulesByName[name] = r
+	g.rules = append(g.rules, r)
+	return nil
+}
+
+func (g *group) setOnError(p daemon.ErrorPolicy, path string) error {
+	if g.onErrorPath != "" && g.onError != p {
+		return fmt.Errorf("reeed: conflicting onError policy %s for group %s (%s), already set to %s in %s", p, g.name, path, g.onError, g.onErrorPath)
+	}
+	g.onError = p
+	g.onErrorPath = path
+	return nil
 }
 
-type ruleMap map[string][]*goja.Object
+type ruleMap map[string]goja.Value
+
+type groupSpec struct {
+	onError *daemon.ErrorPolicy
+	rules   []*goja.Object
+}
+
+// unmarshalGroupSpec converts the value given for a group in the
+// argument to addRules(). The value is either an array of rules or an
+// object of the form {onError: "fail" | "skip", rules: [...]}.
+func unmarshalGroupSpec(vm *goja.Runtime, group string, v goja.Value) (spec groupSpec, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("reeed: can't convert group %s: %s", group, r)
+		}
+	}()
+	o, ok := v.(*goja.Object)
+	if !ok {
+		err = fmt.Errorf("reeed: group %s must be an array or object, but it is %s", group, v)
+		return
+	}
+	if o.ClassName() == "Array" {
+		err = vm.ExportTo(o, &spec.rules)
+		if err != nil {
+			err = fmt.Errorf("reeed: can't convert rules for group %s: %s", group, err)
+		}
+		return
+	}
+	for _, key := range o.Keys() {
+		switch key {
+		case "onError":
+			var p daemon.ErrorPolicy
+			err = p.UnmarshalText([]byte(o.Get(key).String()))
+			if err != nil {
+				err = fmt.Errorf("reeed: invalid onError for group %s: must be \"fail\" or \"skip\" but is %q", group, o.Get(key))
+				return
+			}
+			spec.onError = &p
+		case "rules":
+			err = vm.ExportTo(o.Get(key), &spec.rules)
+			if err != nil {
+				err = fmt.Errorf("reeed: can't convert rules for group %s: %s", group, err)
+				return
+			}
+		default:
+			err = fmt.Errorf("reeed: unknown property %s for group %s", key, group)
+			return
+		}
+	}
+	return
+}
 
 func unmarshalRuleMap(runtime *goja.Runtime, v goja.Value) (rm ruleMap, err error) {
 	defer func() {
@@ -242,8 +410,18 @@ reeed.addRules({
 			rule: function(msg, logger) {
 
 			}
+		},
+		{
+			name: "qux",
+			native: "header-regex",
+			params: { header: "List-Id", regex: "..." }
 		}
-
-	]
+	],
+	"baz": {
+		onError: "skip",
+		rules: [
+			...
+		]
+	}
 })
 */
@@ -0,0 +1,220 @@
+package rule
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/text/unicode/norm"
+)
+
+// asciiDomain returns the lower-cased ASCII (Punycode) form of a domain
+// name, or the lower-cased domain if it can't be converted.
+func asciiDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		return ascii
+	}
+	return domain
+}
+
+// unicodeDomain returns the Unicode form of a domain name, decoding
+// any Punycode labels.
+func unicodeDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if u, err := idna.Display.ToUnicode(domain); err == nil {
+		return u
+	}
+	return domain
+}
+
+// registeredDomain returns the domain name under which a domain was
+// registered, i.e. the public suffix plus one label, using the Public
+// Suffix List snapshot embedded in golang.org/x/net/publicsuffix. For
+// example, the registered domain of "mail.example.co.uk" is
+// "example.co.uk". It returns "" if the domain is itself a public
+// suffix.
+func registeredDomain(domain string) string {
+	ascii := asciiDomain(domain)
+	if ascii == "" {
+		return ""
+	}
+	registered, err := publicsuffix.EffectiveTLDPlusOne(ascii)
+	if err != nil {
+		return ""
+	}
+	return registered
+}
+
+// isIDN reports whether a domain name is internationalized, i.e. has a
+// Punycode label or contains non-ASCII characters.
+func isIDN(domain string) bool {
+	for _, label := range strings.Split(strings.ToLower(domain), ".") {
+		if strings.HasPrefix(label, "xn--") {
+			return true
+		}
+	}
+	for i := 0; i < len(domain); i++ {
+		if domain[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// confusables maps characters to the Latin letters they are commonly
+// confused with. It is the subset of the Unicode confusables data
+// (UTS #39) that is relevant to lower-case domain names, after
+// compatibility decomposition has already folded full-width and
+// mathematical letters.
+var confusables = map[rune]string{
+	// Cyrillic
+	'а': "a", 'е': "e", 'ё': "e", 'һ': "h", 'і': "i", 'ї': "i", 'ј': "j", 'к': "k",
+	'ӏ': "l", 'м': "m", 'о': "o", 'р': "p", 'с': "c", 'у': "y", 'х': "x", 'ѕ': "s",
+	'ԁ': "d", 'ԛ': "q", 'ԝ': "w", 'ү': "y", 'ѵ': "v",
+	// Greek
+	'α': "a", 'β': "b", 'γ': "y", 'ε': "e", 'η': "n", 'ι': "i", 'κ': "k", 'ν': "v",
+	'ο': "o", 'ρ': "p", 'τ': "t", 'υ': "u", 'χ': "x", 'ϲ': "c", 'ϳ': "j", 'ω': "w",
+	// Armenian
+	'օ': "o", 'ո': "n", 'ս': "u", 'հ': "h", 'ց': "g", 'զ': "q",
+	// Latin look-alikes which don't decompose
+	'ɡ': "g", 'ı': "i", 'ȷ': "j", 'ɑ': "a", 'ɩ': "i", 'ł': "l", 'đ': "d", 'ø': "o", 'ħ': "h",
+	'ŀ': "l", 'ƅ': "b", 'ʋ': "v", 'ᴏ': "o", 'ᴄ': "c", 'ᴠ': "v", 'ᴡ': "w", 'ᴢ': "z",
+	'ꜱ': "s", 'ſ': "f",
+	// Digits and symbols
+	'0': "o", '1': "l", '|': "l", 'ǀ': "l",
+}
+
+// confusableSkeleton returns a string such that two strings which look
+// alike have the same skeleton, in the manner of the UTS #39 skeleton
+// algorithm. Unlike UTS #39, the skeleton is case-insensitive and
+// ignores diacritics, since these are easy to miss in a domain name.
+func confusableSkeleton(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(strings.ToLower(s)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if mapped, ok := confusables[r]; ok {
+			b.WriteString(mapped)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	// "rn" looks like "m", and "vv" looks like "w".
+	skeleton := strings.ReplaceAll(b.String(), "m", "rn")
+	return strings.ReplaceAll(skeleton, "vv", "w")
+}
+
+// confusableWith returns the first of the candidate domains which is
+// different from domain, but looks like it or like its registered
+// domain, or "" if there is none.
+func confusableWith(domain string, candidates []string) string {
+	u := unicodeDomain(domain)
+	skeletons := []string{confusableSkeleton(u)}
+	var r string
+	if registered := registeredDomain(domain); registered != "" {
+		r = unicodeDomain(registered)
+		skeletons = append(skeletons, confusableSkeleton(r))
+	}
+	for _, candidate := range candidates {
+		c := unicodeDomain(candidate)
+		if c == "" || c == u || c == r {
+			continue
+		}
+		skeleton := confusableSkeleton(c)
+		for _, s := range skeletons {
+			if s == skeleton {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+var nameAddressRegexp = regexp.MustCompile(`[^\s<>()"',;:@]+@[^\s<>()"',;:@]+\.[^\s<>()"',;:@]+`)
+
+// nameAddress returns the email address contained in a display name,
+// as in "paypal@paypal.com <attacker@example.net>", or "" if there is
+// none.
+func nameAddress(name string) string {
+	return strings.TrimRight(nameAddressRegexp.FindString(name), ".")
+}
+
+func jsMailboxPrototypeDefineDomainProps(vm *goja.Runtime, proto *goja.Object) error {
+	err := defineCachedProperty(vm, proto, "registeredDomain", func(m *jsMailbox) (goja.Value, error) {
+		if registered := registeredDomain(mailboxDomain(m.mailbox)); registered != "" {
+			return vm.ToValue(registered), nil
+		}
+		return goja.Undefined(), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "isIDN", func(m *jsMailbox) (goja.Value, error) {
+		return vm.ToValue(isIDN(mailboxDomain(m.mailbox))), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "unicodeDomain", func(m *jsMailbox) (goja.Value, error) {
+		if domain := mailboxDomain(m.mailbox); domain != "" {
+			return vm.ToValue(unicodeDomain(domain)), nil
+		}
+		return goja.Undefined(), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "nameAddress", func(m *jsMailbox) (goja.Value, error) {
+		if address := nameAddress(m.mailbox.Name); address != "" {
+			return vm.ToValue(address), nil
+		}
+		return goja.Undefined(), nil
+	})
+	if err != nil {
+		return err
+	}
+	err = defineCachedProperty(vm, proto, "nameSpoofed", func(m *jsMailbox) (goja.Value, error) {
+		address := nameAddress(m.mailbox.Name)
+		return vm.ToValue(address != "" && !strings.EqualFold(address, m.mailbox.Address)), nil
+	})
+	if err != nil {
+		return err
+	}
+	return proto.Set("confusableWith", vm.ToValue(func(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+		this, ok := call.This.Export().(*jsMailbox)
+		if !ok {
+			throwJSException(vm, errUnexpectedThisType(&jsMailbox{}, call.This.Export()))
+		}
+		if len(call.Arguments) != 1 {
+			throwJSException(vm, "reeed: confusableWith() requires exactly 1 argument")
+		}
+		var candidates []string
+		if s, ok := call.Arguments[0].Export().(string); ok {
+			candidates = []string{s}
+		} else if err := vm.ExportTo(call.Arguments[0], &candidates); err != nil {
+			throwJSException(vm, errUnexpectedArgType(0, candidates, call.Arguments[0].Export()))
+		}
+		domain := mailboxDomain(this.mailbox)
+		if domain == "" {
+			return goja.Null()
+		}
+		if match := confusableWith(domain, candidates); match != "" {
+			return vm.ToValue(match)
+		}
+		return goja.Null()
+	}))
+}
+
+func mailboxDomain(mailbox *mail.Address) string {
+	if i := strings.LastIndexByte(mailbox.Address, '@'); i >= 0 {
+		return mailbox.Address[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,182 @@
+package rule
+
+import "testing"
+
+func TestAsciiDomain(t *testing.T) {
+	testCases := []struct {
+		domain   string
+		expected string
+	}{
+		{"Example.COM.", "example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"XN--BCHER-KVA.example", "xn--bcher-kva.example"},
+		{"exa mple.com", "exa mple.com"},
+		{"", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.domain, func(t *testing.T) {
+			actual := asciiDomain(testCase.domain)
+
+			if actual != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestUnicodeDomain(t *testing.T) {
+	testCases := []struct {
+		domain   string
+		expected string
+	}{
+		{"xn--bcher-kva.example", "bücher.example"},
+		{"XN--80AK6AA92E.COM", "аррӏе.com"},
+		{"Example.COM.", "example.com"},
+		{"", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.domain, func(t *testing.T) {
+			actual := unicodeDomain(testCase.domain)
+
+			if actual != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRegisteredDomain(t *testing.T) {
+	testCases := []struct {
+		domain   string
+		expected string
+	}{
+		{"mail.example.co.uk", "example.co.uk"},
+		{"Example.COM", "example.com"},
+		{"a.b.github.io", "b.github.io"},
+		{"mail.bücher.de", "xn--bcher-kva.de"},
+		{"co.uk", ""},
+		{"com", ""},
+		{"", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.domain, func(t *testing.T) {
+			actual := registeredDomain(testCase.domain)
+
+			if actual != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIsIDN(t *testing.T) {
+	testCases := []struct {
+		domain   string
+		expected bool
+	}{
+		{"example.com", false},
+		{"XN--80ak6aa92e.com", true},
+		{"mail.xn--bcher-kva.example", true},
+		{"bücher.de", true},
+		{"xn-not-punycode.com", false},
+		{"", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.domain, func(t *testing.T) {
+			actual := isIDN(testCase.domain)
+
+			if actual != testCase.expected {
+				t.Errorf("expected %t, got %t", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestConfusableSkeleton(t *testing.T) {
+	testCases := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"identical", "paypal", "paypal", true},
+		{"cyrillic a", "pаypаl", "paypal", true},
+		{"cyrillic apple", "аррӏе", "apple", true},
+		{"greek omicron", "gοοgle", "google", true},
+		{"digits", "g00gle", "google", true},
+		{"digit one", "paypa1", "paypal", true},
+		{"rn for m", "rnicrosoft", "microsoft", true},
+		{"vv for w", "vvikipedia", "wikipedia", true},
+		{"full width", "ＰａｙＰａｌ", "paypal", true},
+		{"diacritic", "pаypál", "paypal", true},
+		{"case", "PayPal", "paypal", true},
+		{"different", "paypal", "paypai", false},
+		{"different length", "example", "examples", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			a, b := confusableSkeleton(testCase.a), confusableSkeleton(testCase.b)
+
+			if (a == b) != testCase.same {
+				t.Errorf("expected same=%t, got skeletons %q and %q", testCase.same, a, b)
+			}
+		})
+	}
+}
+
+func TestConfusableWith(t *testing.T) {
+	candidates := []string{"example.com", "paypal.com", "microsoft.com"}
+	testCases := []struct {
+		domain   string
+		expected string
+	}{
+		{"pаypal.com", "paypal.com"},
+		{"xn--pypal-4ve.com", "paypal.com"},
+		{"login.paypa1.com", "paypal.com"},
+		{"rnicrosoft.com", "microsoft.com"},
+		{"paypal.com", ""},
+		{"www.paypal.com", ""},
+		{"example.org", ""},
+		{"", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.domain, func(t *testing.T) {
+			actual := confusableWith(testCase.domain, candidates)
+
+			if actual != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNameAddress(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"service@paypal.com", "service@paypal.com"},
+		{"PayPal (service@paypal.com)", "service@paypal.com"},
+		{"'ceo@company.example'", "ceo@company.example"},
+		{"Write to help@example.com.", "help@example.com"},
+		{"Bob Smith", ""},
+		{"user@localhost", ""},
+		{"@example.com", ""},
+		{"", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual := nameAddress(testCase.name)
+
+			if actual != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
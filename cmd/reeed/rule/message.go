@@ -1,6 +1,7 @@
 package rule
 
 import (
+	"bytes"
 	"fmt"
 	"net/mail"
 	"reflect"
@@ -8,7 +9,6 @@ import (
 	"time"
 	"unsafe"
 
-	ics "github.com/arran4/golang-ical"
 	"github.com/dop251/goja"
 	"github.com/gogama/reee-evolution/daemon"
 	"github.com/jhillyerd/enmime"
@@ -23,8 +23,9 @@ func marshalMessage(cont *vmContainer, msg *daemon.Message, tagger daemon.Tagger
 		cont.msgProto = proto
 	}
 	m := &jsMessage{
-		msg:    msg,
-		tagger: tagger,
+		msg:     msg,
+		tagger:  tagger,
+		evalCtx: tagger,
 	}
 	o := cont.vm.ToValue(m).ToObject(cont.vm)
 	err := o.SetPrototype(cont.msgProto)
@@ -34,6 +35,61 @@ func marshalMessage(cont *vmContainer, msg *daemon.Message, tagger daemon.Tagger
 	return o, nil
 }
 
+// jsMessageMaxDepth is the maximum nesting depth of attached messages
+// which are parsed into message objects.
+const jsMessageMaxDepth = 3
+
+// marshalAttachedMessage parses a message/rfc822 part into a message
+// object. The attached message has the same prototype as the message
+// being evaluated, but it has no tags, so it is read-only. The result is
+// null if the part isn't a parseable message or if the nesting depth
+// limit is reached.
+func marshalAttachedMessage(cont *vmContainer, parent *jsMessage, part *enmime.Part) (goja.Value, error) {
+	if o, ok := parent.attachedMessages[part]; ok {
+		return o, nil
+	}
+	o, err := parseAttachedMessage(cont, parent, part)
+	if err != nil {
+		return nil, err
+	}
+	if parent.attachedMessages == nil {
+		parent.attachedMessages = make(map[*enmime.Part]goja.Value)
+	}
+	parent.attachedMessages[part] = o
+	return o, nil
+}
+
+func parseAttachedMessage(cont *vmContainer, parent *jsMessage, part *enmime.Part) (goja.Value, error) {
+	if !isAttachedMessagePart(part) || parent.depth >= jsMessageMaxDepth {
+		return goja.Null(), nil
+	}
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(part.Content))
+	if err != nil {
+		// TODO: Find a way to log this and continue.
+		return goja.Null(), nil
+	}
+	m := &jsMessage{
+		msg:     &daemon.Message{Envelope: envelope},
+		evalCtx: parent.evalCtx,
+		depth:   parent.depth + 1,
+	}
+	o := cont.vm.ToValue(m).ToObject(cont.vm)
+	err = o.SetPrototype(cont.msgProto)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func isAttachedMessagePart(part *enmime.Part) bool {
+	switch strings.ToLower(part.ContentType) {
+	case "message/rfc822", "message/global":
+		return true
+	default:
+		return false
+	}
+}
+
 var jsMessageMailboxHeaderProps = []struct {
 	propName   string
 	headerName string
@@ -115,38 +171,118 @@ func jsMessagePrototype(cont *vmContainer) (*goja.Object, error) {
 		return nil, err
 	}
 	err = jsMessagePrototypeDefineProp(cont.vm, proto, "tags", func(msg *jsMessage) mutableMap {
+		if msg.tagger == nil {
+			return nil
+		}
 		return tagsMap{Tagger: msg.tagger}
 	}, func(mm mutableMap) (goja.Value, error) {
+		if mm == nil {
+			return goja.Null(), nil
+		}
 		return marshalLazyMap(cont.vm, &cont.tagsProto, mm, nil, mm)
 	})
 	if err != nil {
 		return nil, err
 	}
 	// Define attachments property.
-	err = jsMessagePrototypeDefineProp(cont.vm, proto, "attachments", func(msg *jsMessage) []*enmime.Part {
-		return msg.msg.Envelope.Attachments
-	}, func(attachments []*enmime.Part) (goja.Value, error) {
-		return marshalAttachments(cont.vm, &cont.attachmentProto, attachments)
+	err = defineCachedProperty(cont.vm, proto, "attachments", func(msg *jsMessage) (goja.Value, error) {
+		return marshalAttachments(cont, msg, msg.msg.Envelope.Attachments)
 	})
 	if err != nil {
 		return nil, err
 	}
 	// Define the calendar property.
-	err = jsMessagePrototypeDefineProp(cont.vm, proto, "calendar", func(msg *jsMessage) *ics.Calendar {
-		return parseCalendar(msg.msg.Envelope.OtherParts, msg.msg.Envelope.Inlines)
-	}, func(calendar *ics.Calendar) (goja.Value, error) {
-		if calendar == nil {
+	err = defineCachedProperty(cont.vm, proto, "calendar", func(msg *jsMessage) (goja.Value, error) {
+		calendar, err := parseCalendar(msg.msg.Envelope.OtherParts, msg.msg.Envelope.Inlines)
+		if calendar == nil && err == nil {
 			return goja.Null(), nil
 		}
-		return marshalCalendar(cont, calendar)
+		return marshalCalendar(cont, calendar, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Define the parsed authentication results property.
+	err = defineCachedProperty(cont.vm, proto, "auth", func(msg *jsMessage) (goja.Value, error) {
+		return marshalAuth(cont, parseAuthResults(msg.msg.Envelope))
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Define the parsed Received chain property.
+	err = defineCachedProperty(cont.vm, proto, "hops", func(msg *jsMessage) (goja.Value, error) {
+		return marshalHops(cont, parseHops(msg.msg.Envelope))
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Define the mailing list metadata property.
+	err = defineCachedProperty(cont.vm, proto, "list", func(msg *jsMessage) (goja.Value, error) {
+		return marshalMailingList(cont, parseMailingList(msg.msg.Envelope))
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Define the links property.
+	err = defineCachedProperty(cont.vm, proto, "links", func(msg *jsMessage) (goja.Value, error) {
+		return marshalLinks(cont, parseLinks(msg.msg.Envelope))
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Define the remote content property.
+	err = defineCachedProperty(cont.vm, proto, "remoteContent", func(msg *jsMessage) (goja.Value, error) {
+		return marshalRemoteContent(cont, msg, parseRemoteContent(msg.msg.Envelope))
 	})
+	if err != nil {
+		return nil, err
+	}
+	// Define the body helpers property.
+	err = defineCachedProperty(cont.vm, proto, "body", func(msg *jsMessage) (goja.Value, error) {
+		return marshalBody(cont, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Define the thread properties, which are looked up in the message
+	// store.
+	err = defineCachedProperty(cont.vm, proto, "parent", func(msg *jsMessage) (goja.Value, error) {
+		return marshalParent(cont, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(cont.vm, proto, "thread", func(msg *jsMessage) (goja.Value, error) {
+		return marshalThread(cont, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Define the MIME part tree properties.
+	err = defineCachedProperty(cont.vm, proto, "root", func(msg *jsMessage) (goja.Value, error) {
+		if msg.msg.Envelope.Root == nil {
+			return goja.Null(), nil
+		}
+		return marshalPart(cont, msg, msg.msg.Envelope.Root)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(cont.vm, proto, "parts", func(msg *jsMessage) (goja.Value, error) {
+		return marshalPartTree(cont, msg, msg.msg.Envelope.Root)
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	return proto, nil
 }
 
 type jsMessage struct {
-	msg    *daemon.Message
-	tagger daemon.Tagger
+	msg     *daemon.Message
+	tagger  daemon.Tagger // Nil for attached messages, which have no tags
+	evalCtx daemon.Tagger // Tagger of the evaluation, shared by attached messages
+	depth   int           // Zero for the message being evaluated
 
 	// Cached address fields.
 	from    goja.Value
@@ -174,9 +310,46 @@ type jsMessage struct {
 
 	// Cached materialized view of iCalendar part, if available.
 	calendar goja.Value
-}
 
-var jsMessageType = reflect.TypeOf(jsMessage{})
+	// Cached parsed authentication results.
+	auth goja.Value
+
+	// Cached parsed Received chain.
+	hops goja.Value
+
+	// Cached mailing list metadata.
+	list goja.Value
+
+	// Cached links from the HTML and text bodies.
+	links goja.Value
+
+	// Cached remote content referenced from the HTML body.
+	remoteContent goja.Value
+
+	// Cached body helpers, which strip quoted replies and signatures.
+	body goja.Value
+
+	// Cached parent and thread from the message store.
+	parent goja.Value
+	thread goja.Value
+
+	// Cached classifier tokens.
+	classifierTokens []string
+
+	// Cached rule state and history bound to this evaluation.
+	state   goja.Value
+	history goja.Value
+
+	// Cached MIME part tree. Each part is marshalled at most once, so
+	// the same object is reachable from root and from parts.
+	root        goja.Value
+	parts       goja.Value
+	partObjects map[*enmime.Part]goja.Value
+
+	// Cached message objects for message/rfc822 parts, shared between
+	// attachments and parts.
+	attachedMessages map[*enmime.Part]goja.Value
+}
 
 func jsMessagePrototypeDefineAddressesProp(cont *vmContainer, proto *goja.Object, propName, headerName string) error {
 	return jsMessagePrototypeDefineProp(cont.vm, proto, propName, func(msg *jsMessage) string {
@@ -188,33 +361,40 @@ func jsMessagePrototypeDefineAddressesProp(cont *vmContainer, proto *goja.Object
 }
 
 func jsMessagePrototypeDefineProp[T any](vm *goja.Runtime, proto *goja.Object, propName string, get func(*jsMessage) T, convert func(T) (goja.Value, error)) error {
+	return defineCachedProperty(vm, proto, propName, func(msg *jsMessage) (goja.Value, error) {
+		return convert(get(msg))
+	})
+}
+
+// defineCachedProperty adds a property accessor to the prototype which
+// caches the property value in the goja.Value field of S having the
+// same name as the property. The first time the property is accessed,
+// the value is computed and stored in the field, and later accesses
+// return the cached value.
+func defineCachedProperty[S any](vm *goja.Runtime, proto *goja.Object, propName string, compute func(*S) (goja.Value, error)) error {
 	// Determine the offset within the structure of the propField which
 	// contains the cached value.
+	structType := reflect.TypeOf((*S)(nil)).Elem()
 	var propField reflect.StructField
 	var ok bool
-	if propField, ok = jsMessageType.FieldByName(propName); !ok {
-		panic(fmt.Sprintf("property field %q not found in %v", propName, jsMessageType))
+	if propField, ok = structType.FieldByName(propName); !ok {
+		panic(fmt.Sprintf("property field %q not found in %v", propName, structType))
 	}
 
-	// Add a property accessor to the prototype which checks for the
-	// cached property at the given offset within the message, and if
-	// it is not yet cached, fetches it from the underlying daemon
-	// message.
 	return defineGetterProperty(vm, proto, propName, func(_ *goja.Runtime, this any) (goja.Value, error) {
-		if this, ok := this.(*jsMessage); ok {
+		if this, ok := this.(*S); ok {
 			propPtr := (*goja.Value)(unsafe.Add(unsafe.Pointer(this), propField.Offset))
 			if *propPtr != nil {
 				return *propPtr, nil
 			}
-			rawValue := get(this)
-			propValue, err := convert(rawValue)
+			propValue, err := compute(this)
 			if err != nil {
 				return nil, err
 			}
 			*propPtr = propValue
 			return propValue, nil
 		}
-		return nil, errUnexpectedThisType(&jsMessage{}, this)
+		return nil, errUnexpectedThisType(new(S), this)
 	})
 }
 
@@ -229,14 +409,27 @@ func marshalAddresses(cont *vmContainer, addresses string) (goja.Value, error) {
 		list = nil
 	}
 
-	a := make([]goja.Value, len(list))
+	a := make([]any, len(list))
 	for i := range list {
 		a[i], err = marshalMailbox(cont, list[i])
 		if err != nil {
 			return nil, err
 		}
 	}
-	o := cont.vm.ToValue(a)
+	o := cont.vm.NewArray(a...)
+	// The isContact property of an address list is true if any of its
+	// mailboxes is in the address book.
+	err = o.DefineAccessorProperty("isContact", cont.vm.ToValue(func(goja.FunctionCall) goja.Value {
+		for i := range list {
+			if cont.contacts.lookup(list[i].Address) != nil {
+				return cont.vm.ToValue(true)
+			}
+		}
+		return cont.vm.ToValue(false)
+	}), nil, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	if err != nil {
+		return nil, err
+	}
 	return o, nil
 }
 
@@ -246,6 +439,10 @@ func marshalMailbox(cont *vmContainer, mailbox *mail.Address) (goja.Value, error
 		if err != nil {
 			return nil, err
 		}
+		err = jsMailboxPrototypeDefineContactProps(cont, proto)
+		if err != nil {
+			return nil, err
+		}
 		cont.mailboxProto = proto
 	}
 	name := goja.Undefined()
@@ -261,6 +458,7 @@ func marshalMailbox(cont *vmContainer, mailbox *mail.Address) (goja.Value, error
 		}
 	}
 	m := &jsMailbox{
+		mailbox:   mailbox,
 		name:      name,
 		address:   address,
 		localPart: localPart,
@@ -312,6 +510,10 @@ func jsMailboxPrototype(vm *goja.Runtime) (*goja.Object, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = jsMailboxPrototypeDefineDomainProps(vm, proto)
+	if err != nil {
+		return nil, err
+	}
 	return proto, nil
 }
 
@@ -328,54 +530,80 @@ func defineGetterProperty(vm *goja.Runtime, proto *goja.Object, propName string,
 }
 
 type jsMailbox struct {
+	mailbox *mail.Address
+
 	name      goja.Value // string
 	address   goja.Value // string
 	localPart goja.Value // string (local-part of address)
 	domain    goja.Value // string (domain of address)
+
+	// Lazily computed domain and display name properties.
+	registeredDomain goja.Value // string
+	isIDN            goja.Value // boolean
+	unicodeDomain    goja.Value // string
+	nameAddress      goja.Value // string (address in display name)
+	nameSpoofed      goja.Value // boolean
+
+	// Lazily computed address book properties.
+	isContact goja.Value // boolean
+	contact   goja.Value // contact
 }
 
 type jsAttachment struct {
+	msg  *jsMessage
 	part *enmime.Part
 
 	fileName    goja.Value // string
 	fileModDate goja.Value // time.Time
 	contentType goja.Value // string
+
+	// Cached content-derived fields.
+	size                goja.Value // number
+	sha256              goja.Value // string
+	md5                 goja.Value // string
+	sniffedContentType  goja.Value // string
+	contentTypeMismatch goja.Value // boolean
+	text                goja.Value // string
+	entries             goja.Value // []zip entry, or null
+
+	// Cached attached message, for message/rfc822 attachments.
+	message goja.Value
 }
 
-func marshalAttachments(vm *goja.Runtime, protoPtr **goja.Object, attachments []*enmime.Part) (goja.Value, error) {
+func marshalAttachments(cont *vmContainer, msg *jsMessage, attachments []*enmime.Part) (goja.Value, error) {
 	a := make([]goja.Value, len(attachments))
 	for i := range attachments {
-		attachment, err := marshalAttachment(vm, protoPtr, attachments[i])
+		attachment, err := marshalAttachment(cont, msg, attachments[i])
 		if err != nil {
 			return nil, err
 		}
 		a[i] = attachment
 	}
-	return vm.ToValue(a), nil
+	return cont.vm.ToValue(a), nil
 }
 
-func marshalAttachment(vm *goja.Runtime, protoPtr **goja.Object, attachment *enmime.Part) (goja.Value, error) {
-	proto := *protoPtr
-	var err error
-	if proto == nil {
-		proto, err = jsAttachmentPrototype(vm)
+func marshalAttachment(cont *vmContainer, msg *jsMessage, attachment *enmime.Part) (goja.Value, error) {
+	if cont.attachmentProto == nil {
+		proto, err := jsAttachmentPrototype(cont)
 		if err != nil {
 			return nil, err
 		}
-		*protoPtr = proto
+		cont.attachmentProto = proto
 	}
 	a := &jsAttachment{
+		msg:  msg,
 		part: attachment,
 	}
-	o := vm.ToValue(a).ToObject(vm)
-	err = o.SetPrototype(proto)
+	o := cont.vm.ToValue(a).ToObject(cont.vm)
+	err := o.SetPrototype(cont.attachmentProto)
 	if err != nil {
 		return nil, err
 	}
 	return o, nil
 }
 
-func jsAttachmentPrototype(vm *goja.Runtime) (*goja.Object, error) {
+func jsAttachmentPrototype(cont *vmContainer) (*goja.Object, error) {
+	vm := cont.vm
 	proto := vm.NewObject()
 	err := defineGetterProperty(vm, proto, "fileName", jsAttachmentFileName)
 	if err != nil {
@@ -389,6 +617,16 @@ func jsAttachmentPrototype(vm *goja.Runtime) (*goja.Object, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = jsAttachmentPrototypeDefineContentProps(cont, proto)
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "message", func(a *jsAttachment) (goja.Value, error) {
+		return marshalAttachedMessage(cont, a.msg, a.part)
+	})
+	if err != nil {
+		return nil, err
+	}
 	return proto, nil
 }
 
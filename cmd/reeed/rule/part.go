@@ -0,0 +1,223 @@
+package rule
+
+import (
+	"mime"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/jhillyerd/enmime"
+)
+
+type jsPart struct {
+	msg  *jsMessage
+	part *enmime.Part
+
+	id                      goja.Value // string
+	contentType             goja.Value // string
+	disposition             goja.Value // string
+	fileName                goja.Value // string
+	contentId               goja.Value // string
+	charset                 goja.Value // string
+	contentTransferEncoding goja.Value // string
+	headers                 goja.Value // lazy map
+	size                    goja.Value // number
+	text                    goja.Value // string
+	parent                  goja.Value // part
+	children                goja.Value // []part
+	message                 goja.Value // message, for message/rfc822 parts
+}
+
+// marshalPartTree returns a flat array containing every part in the
+// tree rooted at root, in depth-first order.
+func marshalPartTree(cont *vmContainer, msg *jsMessage, root *enmime.Part) (goja.Value, error) {
+	a := make([]goja.Value, 0)
+	var walk func(*enmime.Part) error
+	walk = func(part *enmime.Part) error {
+		for ; part != nil; part = part.NextSibling {
+			o, err := marshalPart(cont, msg, part)
+			if err != nil {
+				return err
+			}
+			a = append(a, o)
+			err = walk(part.FirstChild)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if root != nil {
+		o, err := marshalPart(cont, msg, root)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, o)
+		err = walk(root.FirstChild)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cont.vm.ToValue(a), nil
+}
+
+func marshalPart(cont *vmContainer, msg *jsMessage, part *enmime.Part) (goja.Value, error) {
+	if o, ok := msg.partObjects[part]; ok {
+		return o, nil
+	}
+	if cont.partProto == nil {
+		proto, err := jsPartPrototype(cont)
+		if err != nil {
+			return nil, err
+		}
+		cont.partProto = proto
+	}
+	p := &jsPart{
+		msg:  msg,
+		part: part,
+	}
+	o := cont.vm.ToValue(p).ToObject(cont.vm)
+	err := o.SetPrototype(cont.partProto)
+	if err != nil {
+		return nil, err
+	}
+	if msg.partObjects == nil {
+		msg.partObjects = make(map[*enmime.Part]goja.Value)
+	}
+	msg.partObjects[part] = o
+	return o, nil
+}
+
+func jsPartPrototype(cont *vmContainer) (*goja.Object, error) {
+	proto := cont.vm.NewObject()
+	// Define string properties which are null if not present.
+	stringProps := []struct {
+		propName string
+		get      func(*enmime.Part) string
+	}{
+		{"id", func(part *enmime.Part) string { return part.PartID }},
+		{"contentType", func(part *enmime.Part) string { return part.ContentType }},
+		{"disposition", func(part *enmime.Part) string { return part.Disposition }},
+		{"fileName", func(part *enmime.Part) string { return part.FileName }},
+		{"contentId", func(part *enmime.Part) string { return part.ContentID }},
+		{"charset", func(part *enmime.Part) string { return part.Charset }},
+		{"contentTransferEncoding", func(part *enmime.Part) string {
+			return strings.ToLower(part.Header.Get("Content-Transfer-Encoding"))
+		}},
+	}
+	for _, prop := range stringProps {
+		get := prop.get
+		err := defineCachedProperty(cont.vm, proto, prop.propName, func(p *jsPart) (goja.Value, error) {
+			if value := get(p.part); value != "" {
+				return cont.vm.ToValue(value), nil
+			}
+			return goja.Null(), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := defineCachedProperty(cont.vm, proto, "headers", func(p *jsPart) (goja.Value, error) {
+		hm := partHeadersMap{MIMEHeader: p.part.Header}
+		return marshalLazyMap(cont.vm, &cont.partHeadersProto, hm, hm, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(cont.vm, proto, "size", func(p *jsPart) (goja.Value, error) {
+		return cont.vm.ToValue(len(p.part.Content)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The content of textual parts is already decoded and converted to
+	// UTF-8 by the MIME parser, so decoding the text is only a matter
+	// of wrapping it in a JavaScript string.
+	err = defineCachedProperty(cont.vm, proto, "text", func(p *jsPart) (goja.Value, error) {
+		if !isTextualPart(p.part) {
+			return goja.Null(), nil
+		}
+		return cont.vm.ToValue(string(p.part.Content)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(cont.vm, proto, "parent", func(p *jsPart) (goja.Value, error) {
+		if p.part.Parent == nil {
+			return goja.Null(), nil
+		}
+		return marshalPart(cont, p.msg, p.part.Parent)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(cont.vm, proto, "children", func(p *jsPart) (goja.Value, error) {
+		a := make([]goja.Value, 0)
+		for child := p.part.FirstChild; child != nil; child = child.NextSibling {
+			o, err := marshalPart(cont, p.msg, child)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, o)
+		}
+		return cont.vm.ToValue(a), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(cont.vm, proto, "message", func(p *jsPart) (goja.Value, error) {
+		return marshalAttachedMessage(cont, p.msg, p.part)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
+
+func isTextualPart(part *enmime.Part) bool {
+	return strings.HasPrefix(part.ContentType, "text/")
+}
+
+type partHeadersMap struct {
+	textproto.MIMEHeader
+}
+
+var partHeaderDecoder mime.WordDecoder
+
+func (hm partHeadersMap) keys() []string {
+	keys := make([]string, 0, len(hm.MIMEHeader))
+	for key := range hm.MIMEHeader {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (hm partHeadersMap) get(key string) (string, bool) {
+	values := hm.Values(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return decodePartHeader(values[0]), true
+}
+
+func (hm partHeadersMap) getAll(key string) ([]string, bool) {
+	values := hm.Values(key)
+	if len(values) == 0 {
+		return nil, false
+	}
+	all := make([]string, len(values))
+	for i := range values {
+		all[i] = decodePartHeader(values[i])
+	}
+	return all, true
+}
+
+func decodePartHeader(value string) string {
+	decoded, err := partHeaderDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,338 @@
+package rule
+
+import (
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/jhillyerd/enmime"
+)
+
+// hop is one relay in the path of a message, parsed from a Received
+// header.
+type hop struct {
+	raw             string
+	fromHost        string   // Host name given in HELO/EHLO
+	fromReverseHost string   // Host name from reverse DNS, if recorded
+	fromIP          string   // IP address of the sending host
+	byHost          string   // Host name of the receiving host
+	ips             []string // All IP addresses in the header
+	protocol        string   // Protocol, e.g. "ESMTPS", upper-cased
+	tls             bool     // Whether the hop was protected by TLS
+	id              string   // Receiving host's queue ID
+	date            time.Time
+	delay           *time.Duration // Delay since previous hop, if known
+}
+
+// parseHops parses the Received headers of the envelope. The hops are
+// returned in the order the message travelled, so the first hop is the
+// one closest to the sender and the last hop is the one closest to the
+// recipient. This is the reverse of the order of the headers.
+func parseHops(e *enmime.Envelope) []hop {
+	values := e.GetHeaderValues("Received")
+	hops := make([]hop, len(values))
+	for i := range values {
+		hops[len(values)-1-i] = parseReceived(values[i])
+	}
+	for i := 1; i < len(hops); i++ {
+		if !hops[i-1].date.IsZero() && !hops[i].date.IsZero() {
+			delay := hops[i].date.Sub(hops[i-1].date)
+			hops[i].delay = &delay
+		}
+	}
+	return hops
+}
+
+var (
+	receivedBracketRegexp = regexp.MustCompile(`\[(?:IPv6:)?([0-9A-Fa-f:.]+)\]`)
+	receivedIPv4Regexp    = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`)
+)
+
+// parseReceived parses the value of a Received header. Received headers
+// have the form
+//
+//	from <helo> (<comment>) by <host> (<comment>) via <link>
+//	with <protocol> id <id> for <recipient>; <date>
+//
+// where every clause is optional and comments may appear anywhere.
+func parseReceived(value string) hop {
+	h := hop{
+		raw: value,
+	}
+	tokens, date := value, ""
+	if i := strings.LastIndexByte(value, ';'); i >= 0 {
+		tokens, date = value[:i], value[i+1:]
+	}
+	var clause, commentIP, heloIP string
+	// IP addresses are collected from all clauses except id and for,
+	// since queue IDs such as Gmail's often contain dotted numbers.
+	var ipText strings.Builder
+	for _, token := range tokenizeReceived(tokens) {
+		if clause != "id" && clause != "for" {
+			ipText.WriteString(token.text)
+			ipText.WriteByte(' ')
+		}
+		if token.comment {
+			switch clause {
+			case "from":
+				if h.fromReverseHost == "" {
+					host, _, _ := strings.Cut(token.text, " ")
+					host = strings.TrimSuffix(host, ".")
+					if host != "" && !strings.HasPrefix(host, "[") && strings.Contains(host, ".") && net.ParseIP(host) == nil {
+						h.fromReverseHost = strings.ToLower(host)
+					}
+				}
+				// The address in the comment is the one the receiving
+				// host saw, so it is preferred to the HELO literal.
+				if commentIP == "" {
+					commentIP = firstIP(token.text)
+				}
+			case "with":
+				if strings.Contains(strings.ToUpper(token.text), "TLS") {
+					h.tls = true
+				}
+			}
+			// Exchange records "version=TLS1_2, cipher=...", and Postfix
+			// records "using TLSv1.3 with cipher ...".
+			if strings.Contains(token.text, "version=TLS") || strings.Contains(token.text, "cipher=") || strings.HasPrefix(token.text, "using TLS") {
+				h.tls = true
+			}
+			continue
+		}
+		word := strings.ToLower(token.text)
+		switch word {
+		case "from", "by", "via", "with", "id", "for":
+			clause = word
+			continue
+		}
+		switch clause {
+		case "from":
+			if h.fromHost == "" {
+				h.fromHost = strings.ToLower(strings.Trim(token.text, "[]"))
+				heloIP = firstIP(token.text)
+			}
+		case "by":
+			if h.byHost == "" {
+				h.byHost = strings.ToLower(token.text)
+			}
+		case "with":
+			if h.protocol == "" {
+				h.protocol = strings.ToUpper(token.text)
+				// RFC 3848 protocol types ending in S, such as ESMTPS
+				// and ESMTPSA, indicate TLS.
+				p := strings.TrimSuffix(h.protocol, "A")
+				if strings.HasSuffix(p, "SMTPS") || strings.HasSuffix(p, "LMTPS") {
+					h.tls = true
+				}
+			}
+		case "id":
+			if h.id == "" {
+				h.id = token.text
+			}
+		}
+	}
+	h.fromIP = commentIP
+	if h.fromIP == "" {
+		h.fromIP = heloIP
+	}
+	seen := make(map[string]bool)
+	addIP := func(ip net.IP) {
+		if ip != nil && !seen[ip.String()] {
+			seen[ip.String()] = true
+			h.ips = append(h.ips, ip.String())
+		}
+	}
+	addIP(net.ParseIP(h.fromIP))
+	for _, m := range receivedBracketRegexp.FindAllStringSubmatch(ipText.String(), -1) {
+		addIP(net.ParseIP(m[1]))
+	}
+	for _, m := range receivedIPv4Regexp.FindAllString(ipText.String(), -1) {
+		addIP(net.ParseIP(m))
+	}
+	if date != "" {
+		var words []string
+		for _, token := range tokenizeReceived(date) {
+			if !token.comment {
+				words = append(words, token.text)
+			}
+		}
+		if t, err := mail.ParseDate(strings.Join(words, " ")); err == nil {
+			h.date = t
+		}
+	}
+	return h
+}
+
+func firstIP(s string) string {
+	if m := receivedBracketRegexp.FindStringSubmatch(s); m != nil {
+		if ip := net.ParseIP(m[1]); ip != nil {
+			return ip.String()
+		}
+	}
+	if m := receivedIPv4Regexp.FindString(s); m != "" {
+		if ip := net.ParseIP(m); ip != nil {
+			return ip.String()
+		}
+	}
+	// Exchange records bare IPv6 addresses, without brackets.
+	for _, word := range strings.Fields(s) {
+		if ip := net.ParseIP(strings.Trim(word, "[]")); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+type receivedToken struct {
+	text    string
+	comment bool
+}
+
+// tokenizeReceived splits a Received header into words and
+// parenthesized comments, which may nest.
+func tokenizeReceived(value string) []receivedToken {
+	var tokens []receivedToken
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			tokens = append(tokens, receivedToken{text: b.String()})
+			b.Reset()
+		}
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '(':
+			flush()
+			depth := 1
+			j := i + 1
+			for ; j < len(value) && depth > 0; j++ {
+				switch value[j] {
+				case '\\':
+					j++
+				case '(':
+					depth++
+				case ')':
+					depth--
+				}
+			}
+			end := j - 1
+			if depth > 0 || end > len(value) {
+				end = len(value)
+			}
+			tokens = append(tokens, receivedToken{text: strings.TrimSpace(value[i+1 : end]), comment: true})
+			i = j - 1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			flush()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type jsHop struct {
+	h *hop
+
+	raw             goja.Value // string
+	fromHost        goja.Value // string
+	fromReverseHost goja.Value // string
+	fromIp          goja.Value // string
+	byHost          goja.Value // string
+	ips             goja.Value // []string
+	protocol        goja.Value // string
+	tls             goja.Value // boolean
+	id              goja.Value // string
+	date            goja.Value // Date
+	delay           goja.Value // number of seconds
+}
+
+func marshalHops(cont *vmContainer, hops []hop) (goja.Value, error) {
+	if cont.hopProto == nil {
+		proto, err := jsHopPrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.hopProto = proto
+	}
+	a := make([]goja.Value, len(hops))
+	for i := range hops {
+		h := &jsHop{
+			h: &hops[i],
+		}
+		o := cont.vm.ToValue(h).ToObject(cont.vm)
+		err := o.SetPrototype(cont.hopProto)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = o
+	}
+	return cont.vm.ToValue(a), nil
+}
+
+func jsHopPrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	stringProps := []struct {
+		propName string
+		get      func(*hop) string
+	}{
+		{"raw", func(h *hop) string { return h.raw }},
+		{"fromHost", func(h *hop) string { return h.fromHost }},
+		{"fromReverseHost", func(h *hop) string { return h.fromReverseHost }},
+		{"fromIp", func(h *hop) string { return h.fromIP }},
+		{"byHost", func(h *hop) string { return h.byHost }},
+		{"protocol", func(h *hop) string { return h.protocol }},
+		{"id", func(h *hop) string { return h.id }},
+	}
+	for _, prop := range stringProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(h *jsHop) (goja.Value, error) {
+			if value := get(h.h); value != "" {
+				return vm.ToValue(value), nil
+			}
+			return goja.Null(), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := defineCachedProperty(vm, proto, "ips", func(h *jsHop) (goja.Value, error) {
+		ips := h.h.ips
+		if ips == nil {
+			ips = []string{}
+		}
+		return vm.ToValue(ips), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "tls", func(h *jsHop) (goja.Value, error) {
+		return vm.ToValue(h.h.tls), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "date", func(h *jsHop) (goja.Value, error) {
+		if h.h.date.IsZero() {
+			return goja.Null(), nil
+		}
+		return marshalDate(vm, h.h.date)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "delay", func(h *jsHop) (goja.Value, error) {
+		if h.h.delay == nil {
+			return goja.Null(), nil
+		}
+		return vm.ToValue(h.h.delay.Seconds()), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
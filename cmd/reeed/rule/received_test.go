@@ -0,0 +1,228 @@
+package rule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseReceived(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected hop
+	}{
+		{
+			name: "gmail",
+			value: "from mail-sor-f41.google.com (mail-sor-f41.google.com. [209.85.220.41])\r\n" +
+				"        by mx.google.com with SMTPS id d75a77b69052e-4a7f2b1c2easor8431951cf.8.2024.11.18.17.42.30\r\n" +
+				"        for <user@example.com>\r\n" +
+				"        (Google Transport Security);\r\n" +
+				"        Mon, 18 Nov 2024 17:42:30 -0800 (PST)",
+			expected: hop{
+				fromHost:        "mail-sor-f41.google.com",
+				fromReverseHost: "mail-sor-f41.google.com",
+				fromIP:          "209.85.220.41",
+				byHost:          "mx.google.com",
+				ips:             []string{"209.85.220.41"},
+				protocol:        "SMTPS",
+				tls:             true,
+				id:              "d75a77b69052e-4a7f2b1c2easor8431951cf.8.2024.11.18.17.42.30",
+				date:            time.Date(2024, 11, 18, 17, 42, 30, 0, time.FixedZone("", -8*60*60)),
+			},
+		},
+		{
+			name: "postfix with tls comment",
+			value: "from mail.example.org (mail.example.org [192.0.2.10])\r\n" +
+				"\t(using TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits)\r\n" +
+				"\t key-exchange X25519 server-signature RSA-PSS (2048 bits) server-digest SHA256)\r\n" +
+				"\t(No client certificate requested)\r\n" +
+				"\tby mx.example.com (Postfix) with ESMTP id 4XyZ1q2w3rz9sPc\r\n" +
+				"\tfor <user@example.com>; Tue, 18 Jun 2024 09:15:02 +0200 (CEST)",
+			expected: hop{
+				fromHost:        "mail.example.org",
+				fromReverseHost: "mail.example.org",
+				fromIP:          "192.0.2.10",
+				byHost:          "mx.example.com",
+				ips:             []string{"192.0.2.10"},
+				protocol:        "ESMTP",
+				tls:             true,
+				id:              "4XyZ1q2w3rz9sPc",
+				date:            time.Date(2024, 6, 18, 9, 15, 2, 0, time.FixedZone("", 2*60*60)),
+			},
+		},
+		{
+			name: "exchange online bare ipv6",
+			value: "from AM0PR02MB1234.eurprd02.prod.outlook.com (2603:10a6:208:1::11) by\r\n" +
+				" DB9PR02MB5678.eurprd02.prod.outlook.com (2603:10a6:10:2::22) with\r\n" +
+				" Microsoft SMTP Server (version=TLS1_2,\r\n" +
+				" cipher=TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384) id 15.20.7677.29; Tue, 18 Jun\r\n" +
+				" 2024 07:15:00 +0000",
+			expected: hop{
+				fromHost: "am0pr02mb1234.eurprd02.prod.outlook.com",
+				fromIP:   "2603:10a6:208:1::11",
+				byHost:   "db9pr02mb5678.eurprd02.prod.outlook.com",
+				ips:      []string{"2603:10a6:208:1::11"},
+				protocol: "MICROSOFT",
+				tls:      true,
+				id:       "15.20.7677.29",
+				date:     time.Date(2024, 6, 18, 7, 15, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "helo address literal",
+			value: "from [203.0.113.5] (unknown [198.51.100.23]) by smtp.example.net with ESMTPSA id 7F3A2C0041; Wed, 19 Jun 2024 10:00:00 -0400",
+			expected: hop{
+				fromHost: "203.0.113.5",
+				fromIP:   "198.51.100.23",
+				byHost:   "smtp.example.net",
+				ips:      []string{"198.51.100.23", "203.0.113.5"},
+				protocol: "ESMTPSA",
+				tls:      true,
+				id:       "7F3A2C0041",
+				date:     time.Date(2024, 6, 19, 10, 0, 0, 0, time.FixedZone("", -4*60*60)),
+			},
+		},
+		{
+			name:  "qmail",
+			value: "(qmail 12345 invoked by uid 1000); 18 Jun 2024 07:15:00 -0000",
+			expected: hop{
+				date: time.Date(2024, 6, 18, 7, 15, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "unbalanced parenthesis",
+			value: "from evil.example (unterminated [198.51.100.7] by mx.example.com; Tue, 18 Jun 2024 07:15:00 +0000",
+			expected: hop{
+				fromHost: "evil.example",
+				fromIP:   "198.51.100.7",
+				ips:      []string{"198.51.100.7"},
+				date:     time.Date(2024, 6, 18, 7, 15, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:  "trailing backslash",
+			value: `from a.example (b.example \`,
+			expected: hop{
+				fromHost:        "a.example",
+				fromReverseHost: "b.example",
+			},
+		},
+		{
+			name:  "invalid date",
+			value: "by mx.example.com; yesterday",
+			expected: hop{
+				byHost: "mx.example.com",
+			},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.expected.raw = testCase.value
+
+			actual := parseReceived(testCase.value)
+
+			if !actual.date.Equal(testCase.expected.date) {
+				t.Errorf("expected date %s, got %s", testCase.expected.date, actual.date)
+			}
+			actual.date, testCase.expected.date = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(testCase.expected, actual) {
+				t.Errorf("expected %+v, got %+v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTokenizeReceived(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected []receivedToken
+	}{
+		{
+			name:  "words and comment",
+			value: "from a.example (a.example [192.0.2.1])\r\n\tby b.example",
+			expected: []receivedToken{
+				{text: "from"},
+				{text: "a.example"},
+				{text: "a.example [192.0.2.1]", comment: true},
+				{text: "by"},
+				{text: "b.example"},
+			},
+		},
+		{
+			name:  "nested comment",
+			value: "with ESMTPS (TLS1.3 (256/256 bits)) id x",
+			expected: []receivedToken{
+				{text: "with"},
+				{text: "ESMTPS"},
+				{text: "TLS1.3 (256/256 bits)", comment: true},
+				{text: "id"},
+				{text: "x"},
+			},
+		},
+		{
+			name:  "comment without spaces",
+			value: "by b.example(Postfix)with SMTP",
+			expected: []receivedToken{
+				{text: "by"},
+				{text: "b.example"},
+				{text: "Postfix", comment: true},
+				{text: "with"},
+				{text: "SMTP"},
+			},
+		},
+		{
+			name:  "escaped parenthesis",
+			value: `(a \) b) c`,
+			expected: []receivedToken{
+				{text: `a \) b`, comment: true},
+				{text: "c"},
+			},
+		},
+		{
+			name:  "unbalanced parenthesis",
+			value: "from a.example (b.example (c",
+			expected: []receivedToken{
+				{text: "from"},
+				{text: "a.example"},
+				{text: "b.example (c", comment: true},
+			},
+		},
+		{
+			name:  "trailing backslash",
+			value: `x (y \`,
+			expected: []receivedToken{
+				{text: "x"},
+				{text: `y \`, comment: true},
+			},
+		},
+		{
+			name:  "unmatched close",
+			value: "a ) b",
+			expected: []receivedToken{
+				{text: "a"},
+				{text: ")"},
+				{text: "b"},
+			},
+		},
+		{
+			name:  "whitespace only",
+			value: " \t\r\n ",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual := tokenizeReceived(testCase.value)
+
+			if !reflect.DeepEqual(testCase.expected, actual) {
+				t.Errorf("expected %+v, got %+v", testCase.expected, actual)
+			}
+		})
+	}
+}
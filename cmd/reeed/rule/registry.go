@@ -0,0 +1,134 @@
+package rule
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/gogama/reee-evolution/daemon"
+)
+
+// Constructor creates a named native rule from its parameters.
+type Constructor func(name string, params Params) (daemon.Rule, error)
+
+var registry struct {
+	mu   sync.RWMutex
+	ctor map[string]Constructor
+}
+
+// Register makes a native rule constructor available under the given
+// kind, so that rule scripts and declarative rule files can instantiate
+// it. If Register is called twice with the same kind, or if ctor is
+// nil, it panics.
+func Register(kind string, ctor Constructor) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if ctor == nil {
+		panic("rule: Register constructor is nil")
+	}
+	if _, dup := registry.ctor[kind]; dup {
+		panic("rule: Register called twice for kind " + kind)
+	}
+	if registry.ctor == nil {
+		registry.ctor = make(map[string]Constructor)
+	}
+	registry.ctor[kind] = ctor
+}
+
+// Kinds returns a sorted list of the registered native rule kinds.
+func Kinds() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	kinds := make([]string, 0, len(registry.ctor))
+	for kind := range registry.ctor {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func newNativeRule(kind, name string, params Params) (daemon.Rule, error) {
+	registry.mu.RLock()
+	ctor := registry.ctor[kind]
+	registry.mu.RUnlock()
+	if ctor == nil {
+		return nil, fmt.Errorf("reeed: unknown native rule kind %q for rule %s", kind, name)
+	}
+	r, err := ctor(name, params)
+	if err != nil {
+		return nil, fmt.Errorf("reeed: can't construct %s rule %s: %s", kind, name, err)
+	}
+	return r, nil
+}
+
+// Params holds the parameters given to a native rule constructor.
+type Params struct {
+	values map[string]any
+	dir    string
+}
+
+func newParams(values map[string]any, path string) Params {
+	return Params{
+		values: values,
+		dir:    filepath.Dir(path),
+	}
+}
+
+// Value returns the raw value of a parameter.
+func (p Params) Value(key string) (value any, ok bool) {
+	value, ok = p.values[key]
+	return
+}
+
+// String returns the value of a required string parameter.
+func (p Params) String(key string) (string, error) {
+	value, ok := p.values[key]
+	if !ok {
+		return "", fmt.Errorf("missing parameter %s", key)
+	} else if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("parameter %s must be a string, but it is a %T", key, value)
+}
+
+// Strings returns the value of a required parameter which is either a
+// string or an array of strings.
+func (p Params) Strings(key string) ([]string, error) {
+	value, ok := p.values[key]
+	if !ok {
+		return nil, fmt.Errorf("missing parameter %s", key)
+	}
+	switch value := value.(type) {
+	case string:
+		return []string{value}, nil
+	case []any:
+		list := make([]string, len(value))
+		for i := range value {
+			s, ok := value[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("parameter %s[%d] must be a string, but it is a %T", key, i, value[i])
+			}
+			list[i] = s
+		}
+		return list, nil
+	case []string:
+		return value, nil
+	default:
+		return nil, fmt.Errorf("parameter %s must be a string or array of strings, but it is a %T", key, value)
+	}
+}
+
+// Path returns the value of a required string parameter naming a
+// file. A relative path is resolved against the directory of the rule
+// file which instantiated the rule.
+func (p Params) Path(key string) (string, error) {
+	path, err := p.String(key)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.dir, path)
+	}
+	return path, nil
+}
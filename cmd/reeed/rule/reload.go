@@ -0,0 +1,142 @@
+package rule
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogama/reee-evolution/log"
+)
+
+// reloadInterval is the minimum time between checks for changes to the
+// files backing a reloader.
+const reloadInterval = 5 * time.Second
+
+// reloader holds a value loaded from a set of files and directories,
+// and reloads it when the files change. Changes are detected lazily,
+// at most once per reloadInterval, by comparing the names, sizes and
+// modification times of the files. If reloading fails, the error is
+// logged and the previous value is kept.
+type reloader[T any] struct {
+	name   string
+	paths  []string
+	exts   []string
+	load   func(files []string) (T, error)
+	logger log.Printer
+
+	mu      sync.Mutex
+	checked time.Time
+	sig     string
+	value   T
+}
+
+func newReloader[T any](name string, paths, exts []string, logger log.Printer, load func(files []string) (T, error)) (*reloader[T], error) {
+	r := &reloader[T]{
+		name:   name,
+		paths:  paths,
+		exts:   exts,
+		load:   load,
+		logger: logger,
+	}
+	files, sig, err := r.scan()
+	if err != nil {
+		return nil, err
+	}
+	value, err := load(files)
+	if err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	r.sig = sig
+	r.value = value
+	log.Verbose(logger, "loaded %s from %d files.", name, len(files))
+	return r, nil
+}
+
+// get returns the current value, reloading it first if the files have
+// changed.
+func (r *reloader[T]) get() T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.checked) < reloadInterval {
+		return r.value
+	}
+	r.checked = now
+	files, sig, err := r.scan()
+	if err != nil {
+		log.Normal(r.logger, "failed to check %s for changes: %s", r.name, err)
+		return r.value
+	} else if sig == r.sig {
+		return r.value
+	}
+	value, err := r.load(files)
+	if err != nil {
+		log.Normal(r.logger, "failed to reload %s, keeping previous version: %s", r.name, err)
+		return r.value
+	}
+	r.sig = sig
+	r.value = value
+	log.Normal(r.logger, "reloaded %s from %d files.", r.name, len(files))
+	return value
+}
+
+// scan finds the files to load and computes their signature. A path
+// which is a file is always included. Files within a directory are
+// included if they have one of the reloader's extensions.
+func (r *reloader[T]) scan() (files []string, sig string, err error) {
+	var b strings.Builder
+	add := func(path string, info fs.FileInfo) {
+		files = append(files, path)
+		fmt.Fprintf(&b, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	for _, path := range r.paths {
+		var info fs.FileInfo
+		info, err = os.Stat(path)
+		if err != nil {
+			return
+		}
+		if !info.IsDir() {
+			add(path, info)
+			continue
+		}
+		var dirFiles []string
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && r.hasExt(p) {
+				dirFiles = append(dirFiles, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		sort.Strings(dirFiles)
+		for _, p := range dirFiles {
+			info, err = os.Stat(p)
+			if err != nil {
+				return
+			}
+			add(p, info)
+		}
+	}
+	sig = b.String()
+	return
+}
+
+func (r *reloader[T]) hasExt(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range r.exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,502 @@
+package rule
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/gogama/reee-evolution/daemon"
+	"github.com/gogama/reee-evolution/log"
+	"github.com/jhillyerd/enmime"
+	"golang.org/x/net/html"
+)
+
+func init() {
+	Register("has-tracking", newHasTrackingRule)
+}
+
+// remoteContentMax bounds the number of resources extracted from one
+// message.
+const remoteContentMax = 1000
+
+// remoteResource is an image, stylesheet or font which a mail client
+// fetches from a remote server when it displays the HTML body of a
+// message.
+type remoteResource struct {
+	kind   string // "image", "stylesheet" or "font"
+	url    string
+	host   string // Lower-cased host name or IP, without port
+	pixel  bool   // Whether the image is declared to be 1x1 or smaller
+	hidden bool   // Whether the image, or an element containing it, is hidden
+	token  bool   // Whether the query string looks like a per-recipient token
+}
+
+// inlineRef is a cid: reference from the HTML body of a message to one
+// of its inline parts.
+type inlineRef struct {
+	cid  string
+	part *enmime.Part // Nil if no part has the content ID
+}
+
+// remoteContent is the content referenced by the HTML body of a
+// message.
+type remoteContent struct {
+	resources []remoteResource
+	inline    []inlineRef
+}
+
+// tracking reports whether any remote resource looks like a tracking
+// pixel or carries a per-recipient token.
+func (rc *remoteContent) tracking() bool {
+	for i := range rc.resources {
+		r := &rc.resources[i]
+		if r.token || (r.kind == "image" && (r.pixel || r.hidden)) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	cssURLRegexp      = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+	cssImportRegexp   = regexp.MustCompile(`(?i)@import\s+['"]([^'"]+)['"]`)
+	cssFontFaceRegexp = regexp.MustCompile(`(?is)@font-face\s*\{[^}]*\}`)
+	urlTokenRegexp    = regexp.MustCompile(`^[A-Za-z0-9_\-.=+/%]{16,}$`)
+)
+
+// parseRemoteContent finds the remote resources and cid: references in
+// the HTML body of the envelope.
+func parseRemoteContent(e *enmime.Envelope) *remoteContent {
+	rc := &remoteContent{
+		resources: []remoteResource{},
+		inline:    []inlineRef{},
+	}
+	if e.HTML == "" {
+		return rc
+	}
+	doc, err := html.Parse(strings.NewReader(e.HTML))
+	if err != nil {
+		return rc
+	}
+	p := remoteContentParser{
+		rc:   rc,
+		e:    e,
+		cids: make(map[string]bool),
+	}
+	p.walk(doc, false)
+	return rc
+}
+
+type remoteContentParser struct {
+	rc   *remoteContent
+	e    *enmime.Envelope
+	cids map[string]bool
+}
+
+func (p *remoteContentParser) walk(n *html.Node, hidden bool) {
+	if n.Type == html.ElementNode {
+		style := parseInlineStyle(htmlAttr(n, "style"))
+		hidden = hidden || isHiddenElement(n, style)
+		switch n.Data {
+		case "img":
+			w, h := htmlDimension(htmlAttr(n, "width"), style["width"]), htmlDimension(htmlAttr(n, "height"), style["height"])
+			pixel := w >= 0 && w <= 1 && h >= 0 && h <= 1
+			p.add("image", htmlAttr(n, "src"), pixel, hidden)
+		case "input":
+			if strings.EqualFold(htmlAttr(n, "type"), "image") {
+				p.add("image", htmlAttr(n, "src"), false, hidden)
+			}
+		case "link":
+			p.addLinkElement(n, hidden)
+		case "style":
+			p.addCSS(htmlText(n), hidden)
+		}
+		if background := htmlAttr(n, "background"); background != "" {
+			p.add("image", background, false, hidden)
+		}
+		if s := htmlAttr(n, "style"); s != "" {
+			p.addCSS(s, hidden)
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		p.walk(c, hidden)
+	}
+}
+
+func (p *remoteContentParser) addLinkElement(n *html.Node, hidden bool) {
+	href := htmlAttr(n, "href")
+	rel := strings.Fields(strings.ToLower(htmlAttr(n, "rel")))
+	for _, r := range rel {
+		switch r {
+		case "stylesheet":
+			p.add("stylesheet", href, false, hidden)
+			return
+		case "preload", "prefetch":
+			if strings.EqualFold(htmlAttr(n, "as"), "font") {
+				p.add("font", href, false, hidden)
+				return
+			}
+		case "icon":
+			p.add("image", href, false, hidden)
+			return
+		}
+	}
+}
+
+// addCSS adds the resources referenced from a style sheet or inline
+// style attribute. URLs within @font-face rules are fonts, URLs in
+// @import rules are stylesheets, and other URLs are images.
+func (p *remoteContentParser) addCSS(css string, hidden bool) {
+	for _, block := range cssFontFaceRegexp.FindAllString(css, -1) {
+		for _, m := range cssURLRegexp.FindAllStringSubmatch(block, -1) {
+			p.add("font", m[1], false, hidden)
+		}
+	}
+	css = cssFontFaceRegexp.ReplaceAllString(css, "")
+	for _, m := range cssImportRegexp.FindAllStringSubmatch(css, -1) {
+		p.add("stylesheet", m[1], false, hidden)
+	}
+	css = cssImportRegexp.ReplaceAllString(css, "")
+	for _, m := range cssURLRegexp.FindAllStringSubmatch(css, -1) {
+		p.add("image", m[1], false, hidden)
+	}
+}
+
+func (p *remoteContentParser) add(kind, rawURL string, pixel, hidden bool) {
+	rawURL = strings.TrimSpace(rawURL)
+	lower := strings.ToLower(rawURL)
+	if strings.HasPrefix(lower, "cid:") {
+		cid := rawURL[4:]
+		if unescaped, err := url.PathUnescape(cid); err == nil {
+			cid = unescaped
+		}
+		if !p.cids[cid] {
+			p.cids[cid] = true
+			p.rc.inline = append(p.rc.inline, inlineRef{
+				cid:  cid,
+				part: findInlinePart(p.e, cid),
+			})
+		}
+		return
+	}
+	if strings.HasPrefix(lower, "//") {
+		rawURL = "https:" + rawURL
+	} else if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return
+	}
+	if len(p.rc.resources) >= remoteContentMax {
+		return
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	p.rc.resources = append(p.rc.resources, remoteResource{
+		kind:   kind,
+		url:    rawURL,
+		host:   strings.ToLower(u.Hostname()),
+		pixel:  kind == "image" && pixel,
+		hidden: hidden,
+		token:  hasURLToken(u),
+	})
+}
+
+// findInlinePart returns the inline part having the given content ID,
+// looking first at the inline parts and then at the other parts and
+// attachments, which is where enmime puts some multipart/related parts.
+func findInlinePart(e *enmime.Envelope, cid string) *enmime.Part {
+	for _, parts := range [][]*enmime.Part{e.Inlines, e.OtherParts, e.Attachments} {
+		for _, part := range parts {
+			if part.ContentID != "" && strings.EqualFold(strings.Trim(part.ContentID, "<>"), cid) {
+				return part
+			}
+		}
+	}
+	return nil
+}
+
+// hasURLToken reports whether any query parameter of a URL looks like
+// a per-recipient token: either an email address, or a long opaque
+// string mixing letters and digits. Google Analytics campaign
+// parameters are ignored.
+func hasURLToken(u *url.URL) bool {
+	for key, values := range u.Query() {
+		if strings.HasPrefix(strings.ToLower(key), "utm_") {
+			continue
+		}
+		for _, value := range values {
+			if strings.Contains(value, "@") {
+				return true
+			}
+			if urlTokenRegexp.MatchString(value) && strings.ContainsAny(value, "0123456789") &&
+				strings.IndexFunc(value, func(r rune) bool { return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' }) >= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseInlineStyle(style string) map[string]string {
+	m := make(map[string]string)
+	for _, decl := range strings.Split(style, ";") {
+		key, value, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+		m[strings.ToLower(strings.TrimSpace(key))] = strings.ToLower(value)
+	}
+	return m
+}
+
+func isHiddenElement(n *html.Node, style map[string]string) bool {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && strings.EqualFold(attr.Key, "hidden") {
+			return true
+		}
+	}
+	if style["display"] == "none" || style["visibility"] == "hidden" {
+		return true
+	}
+	if opacity, err := strconv.ParseFloat(style["opacity"], 64); err == nil && opacity == 0 {
+		return true
+	}
+	return htmlDimension(htmlAttr(n, "width"), style["width"]) == 0 ||
+		htmlDimension(htmlAttr(n, "height"), style["height"]) == 0
+}
+
+// htmlDimension returns the size in pixels given by a width or height
+// attribute or style property, preferring the style, or -1 if neither
+// gives a size in pixels.
+func htmlDimension(attr, style string) int {
+	for _, value := range []string{style, attr} {
+		value = strings.TrimSuffix(strings.TrimSpace(value), "px")
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return int(n)
+		}
+	}
+	return -1
+}
+
+type hasTrackingRule struct {
+	name string
+	tag  string
+}
+
+// newHasTrackingRule matches if the HTML body of the message contains
+// a tracking pixel or a remote resource with a per-recipient token. If
+// it matches, it sets the tag named by the optional "tag" parameter,
+// which defaults to "tracking", to "true".
+func newHasTrackingRule(name string, params Params) (daemon.Rule, error) {
+	tag := "tracking"
+	if _, ok := params.Value("tag"); ok {
+		var err error
+		if tag, err = params.String("tag"); err != nil {
+			return nil, err
+		}
+	}
+	return &hasTrackingRule{
+		name: name,
+		tag:  tag,
+	}, nil
+}
+
+func (r *hasTrackingRule) String() string {
+	return r.name
+}
+
+func (r *hasTrackingRule) Eval(_ context.Context, _ log.Printer, msg *daemon.Message, tagger daemon.Tagger) (match bool, err error) {
+	if !parseRemoteContent(msg.Envelope).tracking() {
+		return false, nil
+	}
+	if r.tag != "" {
+		tagger.SetTag(r.tag, "true")
+	}
+	return true, nil
+}
+
+type jsRemoteContent struct {
+	msg *jsMessage
+	rc  *remoteContent
+
+	resources goja.Value // []remote resource
+	inline    goja.Value // []inline reference
+	tracking  goja.Value // boolean
+}
+
+type jsRemoteResource struct {
+	r *remoteResource
+
+	kind   goja.Value // string
+	url    goja.Value // string
+	host   goja.Value // string
+	pixel  goja.Value // boolean
+	hidden goja.Value // boolean
+	token  goja.Value // boolean
+}
+
+type jsInlineRef struct {
+	msg *jsMessage
+	ref *inlineRef
+
+	cid  goja.Value // string
+	part goja.Value // part, or null if unresolved
+}
+
+func marshalRemoteContent(cont *vmContainer, msg *jsMessage, rc *remoteContent) (goja.Value, error) {
+	if cont.remoteContentProto == nil {
+		proto, err := jsRemoteContentPrototype(cont)
+		if err != nil {
+			return nil, err
+		}
+		cont.remoteContentProto = proto
+	}
+	c := &jsRemoteContent{
+		msg: msg,
+		rc:  rc,
+	}
+	o := cont.vm.ToValue(c).ToObject(cont.vm)
+	err := o.SetPrototype(cont.remoteContentProto)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func jsRemoteContentPrototype(cont *vmContainer) (*goja.Object, error) {
+	vm := cont.vm
+	proto := vm.NewObject()
+	err := defineCachedProperty(vm, proto, "resources", func(c *jsRemoteContent) (goja.Value, error) {
+		return marshalRemoteResources(cont, c.rc.resources)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "inline", func(c *jsRemoteContent) (goja.Value, error) {
+		return marshalInlineRefs(cont, c.msg, c.rc.inline)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "tracking", func(c *jsRemoteContent) (goja.Value, error) {
+		return vm.ToValue(c.rc.tracking()), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
+
+func marshalRemoteResources(cont *vmContainer, resources []remoteResource) (goja.Value, error) {
+	if cont.remoteResourceProto == nil {
+		proto, err := jsRemoteResourcePrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.remoteResourceProto = proto
+	}
+	a := make([]goja.Value, len(resources))
+	for i := range resources {
+		r := &jsRemoteResource{
+			r: &resources[i],
+		}
+		o := cont.vm.ToValue(r).ToObject(cont.vm)
+		err := o.SetPrototype(cont.remoteResourceProto)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = o
+	}
+	return cont.vm.ToValue(a), nil
+}
+
+func jsRemoteResourcePrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	stringProps := []struct {
+		propName string
+		get      func(*remoteResource) string
+	}{
+		{"kind", func(r *remoteResource) string { return r.kind }},
+		{"url", func(r *remoteResource) string { return r.url }},
+		{"host", func(r *remoteResource) string { return r.host }},
+	}
+	for _, prop := range stringProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(r *jsRemoteResource) (goja.Value, error) {
+			if value := get(r.r); value != "" {
+				return vm.ToValue(value), nil
+			}
+			return goja.Null(), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	boolProps := []struct {
+		propName string
+		get      func(*remoteResource) bool
+	}{
+		{"pixel", func(r *remoteResource) bool { return r.pixel }},
+		{"hidden", func(r *remoteResource) bool { return r.hidden }},
+		{"token", func(r *remoteResource) bool { return r.token }},
+	}
+	for _, prop := range boolProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(r *jsRemoteResource) (goja.Value, error) {
+			return vm.ToValue(get(r.r)), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proto, nil
+}
+
+func marshalInlineRefs(cont *vmContainer, msg *jsMessage, refs []inlineRef) (goja.Value, error) {
+	if cont.inlineRefProto == nil {
+		proto, err := jsInlineRefPrototype(cont)
+		if err != nil {
+			return nil, err
+		}
+		cont.inlineRefProto = proto
+	}
+	a := make([]goja.Value, len(refs))
+	for i := range refs {
+		r := &jsInlineRef{
+			msg: msg,
+			ref: &refs[i],
+		}
+		o := cont.vm.ToValue(r).ToObject(cont.vm)
+		err := o.SetPrototype(cont.inlineRefProto)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = o
+	}
+	return cont.vm.ToValue(a), nil
+}
+
+func jsInlineRefPrototype(cont *vmContainer) (*goja.Object, error) {
+	vm := cont.vm
+	proto := vm.NewObject()
+	err := defineCachedProperty(vm, proto, "cid", func(r *jsInlineRef) (goja.Value, error) {
+		return vm.ToValue(r.ref.cid), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "part", func(r *jsInlineRef) (goja.Value, error) {
+		if r.ref.part == nil {
+			return goja.Null(), nil
+		}
+		return marshalPart(cont, r.msg, r.ref.part)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
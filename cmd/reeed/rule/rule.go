@@ -8,12 +8,13 @@ import (
 	"github.com/dop251/goja"
 	"github.com/gogama/reee-evolution/daemon"
 	"github.com/gogama/reee-evolution/log"
+	"github.com/gogama/reee-evolution/protocol"
 )
 
 type ruleFunc func(msg goja.Value, logger goja.Value) (goja.Value, error)
 
 type jsRule struct {
-	parent *jsGroup
+	parent *group
 	cont   *vmContainer
 	name   string
 	f      ruleFunc
@@ -50,10 +51,12 @@ func (r *jsRule) Eval(ctx context.Context, logger log.Printer, msg *daemon.Messa
 	return
 }
 
-func unmarshalRule(vm *goja.Runtime, o *goja.Object, cont *vmContainer, i int, parent *jsGroup) (rule *jsRule, err error) {
+func unmarshalRule(vm *goja.Runtime, o *goja.Object, cont *vmContainer, i int, parent *group) (rule daemon.Rule, err error) {
 	keys := o.Keys()
 	var name string
 	var f ruleFunc
+	var native string
+	var params map[string]any
 	for _, key := range keys {
 		switch key {
 		case "name":
@@ -69,16 +72,34 @@ func unmarshalRule(vm *goja.Runtime, o *goja.Object, cont *vmContainer, i int, p
 				err = fmt.Errorf("reeed: can't unmarshal rule function: rule %d in group %s: %s", i, parent.name, err)
 				return
 			}
-		}
-		if name != "" && f != nil {
-			break
+		case "native":
+			native = o.Get("native").String()
+		case "params":
+			err = vm.ExportTo(o.Get("params"), &params)
+			if err != nil {
+				err = fmt.Errorf("reeed: can't unmarshal native rule params: rule %d in group %s: %s", i, parent.name, err)
+				return
+			}
 		}
 	}
 	if name == "" {
 		err = fmt.Errorf("reeed: can't determine rule name: rule %d in group %s", i, parent.name)
 		return
 	}
-	// TODO: Validation on rule name here please.
+	if err = protocol.ValidateName("rule name", name); err != nil {
+		err = fmt.Errorf("reeed: %s: rule %d in group %s", err, i, parent.name)
+		return
+	}
+	if native != "" {
+		if f != nil {
+			err = fmt.Errorf("reeed: rule %s in group %s has both a rule function and a native kind", name, parent.name)
+			return
+		}
+		return newNativeRule(native, name, newParams(params, cont.path))
+	} else if f == nil {
+		err = fmt.Errorf("reeed: missing rule function: rule %s in group %s", name, parent.name)
+		return
+	}
 	rule = &jsRule{
 		parent: parent,
 		cont:   cont,
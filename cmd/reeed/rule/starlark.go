@@ -0,0 +1,336 @@
+package rule
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gogama/reee-evolution/daemon"
+	"github.com/gogama/reee-evolution/log"
+	"github.com/gogama/reee-evolution/protocol"
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+// starMaxExecutionSteps bounds the computation a single evaluation of
+// a Starlark rule may perform.
+const starMaxExecutionSteps = 50_000_000
+
+// LoadStarlark loads rules from a Starlark file. The file registers its
+// rules by calling the add_rules() builtin, which takes a dict of the
+// same shape as the argument to the JavaScript addRules() function.
+func (set *GroupSet) LoadStarlark(ctx context.Context, logger log.Printer, path string) error {
+	start := time.Now()
+
+	text, err := loadFileText(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	thread := &starlark.Thread{
+		Name: path,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Verbose(logger, "%s", msg)
+		},
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	hc := &starHookContainer{
+		set:    set,
+		path:   path,
+		groups: make(map[string]bool),
+	}
+	predeclared := starlark.StringDict{
+		"add_rules": starlark.NewBuiltin("add_rules", hc.addRules),
+		"time":      starlarktime.Module,
+	}
+	globals, err := starlark.ExecFile(thread, path, text, predeclared)
+	if err != nil {
+		return err
+	}
+	globals.Freeze()
+
+	elapsed := time.Since(start)
+	log.Verbose(logger, "loaded %d groups and %d rules from %s in %s.", len(hc.groups), hc.numRules, path, elapsed)
+	return nil
+}
+
+type starHookContainer struct {
+	set      *GroupSet
+	path     string
+	groups   map[string]bool
+	numRules int
+}
+
+func (hc *starHookContainer) addRules(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var rm *starlark.Dict
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &rm); err != nil {
+		return nil, err
+	}
+	// Freezing the rules makes them, and any closures they contain,
+	// safe to evaluate concurrently.
+	rm.Freeze()
+	groups := make([]string, 0, rm.Len())
+	specs := make(map[string]starlark.Value, rm.Len())
+	for _, item := range rm.Items() {
+		g, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("%s: group name must be a string, but it is a %s", fn.Name(), item[0].Type())
+		}
+		if err := protocol.ValidateName("group name", g); err != nil {
+			return nil, fmt.Errorf("%s: %s", fn.Name(), err)
+		}
+		groups = append(groups, g)
+		specs[g] = item[1]
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		hc.groups[g] = true
+		group := hc.set.group(g)
+		onError, rules, err := unmarshalStarGroupSpec(g, specs[g])
+		if err != nil {
+			return nil, err
+		}
+		if onError != nil {
+			err = group.setOnError(*onError, hc.path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for i, r := range rules {
+			var rule daemon.Rule
+			rule, err = unmarshalStarRule(r, hc.path, i, group)
+			if err != nil {
+				return nil, err
+			}
+			err = group.addRule(rule, hc.path)
+			if err != nil {
+				return nil, err
+			}
+			hc.numRules++
+		}
+	}
+	return starlark.None, nil
+}
+
+func unmarshalStarGroupSpec(group string, v starlark.Value) (onError *daemon.ErrorPolicy, rules []starlark.Value, err error) {
+	switch v := v.(type) {
+	case *starlark.List:
+		rules = starElems(v)
+		return
+	case *starlark.Dict:
+		for _, item := range v.Items() {
+			key, _ := starlark.AsString(item[0])
+			switch key {
+			case "onError":
+				s, ok := starlark.AsString(item[1])
+				var p daemon.ErrorPolicy
+				if !ok || p.UnmarshalText([]byte(s)) != nil {
+					err = fmt.Errorf("reeed: invalid onError for group %s: must be \"fail\" or \"skip\" but is %s", group, item[1])
+					return
+				}
+				onError = &p
+			case "rules":
+				list, ok := item[1].(*starlark.List)
+				if !ok {
+					err = fmt.Errorf("reeed: rules for group %s must be a list, but it is a %s", group, item[1].Type())
+					return
+				}
+				rules = starElems(list)
+			default:
+				err = fmt.Errorf("reeed: unknown property %s for group %s", item[0], group)
+				return
+			}
+		}
+		return
+	default:
+		err = fmt.Errorf("reeed: group %s must be a list or dict, but it is a %s", group, v.Type())
+		return
+	}
+}
+
+func unmarshalStarRule(v starlark.Value, path string, i int, parent *group) (rule daemon.Rule, err error) {
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		err = fmt.Errorf("reeed: rule %d in group %s must be a dict, but it is a %s", i, parent.name, v.Type())
+		return
+	}
+	var name string
+	var fn starlark.Callable
+	var native string
+	var params map[string]any
+	for _, item := range d.Items() {
+		key, _ := starlark.AsString(item[0])
+		switch key {
+		case "name":
+			name, _ = starlark.AsString(item[1])
+			if name == "" {
+				err = fmt.Errorf("reeed: blank rule name: rule %d in group %s", i, parent.name)
+				return
+			}
+		case "rule":
+			if fn, ok = item[1].(starlark.Callable); !ok {
+				err = fmt.Errorf("reeed: rule function must be callable: rule %d in group %s", i, parent.name)
+				return
+			}
+		case "native":
+			native, _ = starlark.AsString(item[1])
+		case "params":
+			var p any
+			p, err = fromStarValue(item[1])
+			if err != nil {
+				err = fmt.Errorf("reeed: can't unmarshal native rule params: rule %d in group %s: %s", i, parent.name, err)
+				return
+			} else if params, ok = p.(map[string]any); !ok {
+				err = fmt.Errorf("reeed: native rule params must be a dict: rule %d in group %s", i, parent.name)
+				return
+			}
+		}
+	}
+	if name == "" {
+		err = fmt.Errorf("reeed: can't determine rule name: rule %d in group %s", i, parent.name)
+		return
+	}
+	if err = protocol.ValidateName("rule name", name); err != nil {
+		err = fmt.Errorf("reeed: %s: rule %d in group %s", err, i, parent.name)
+		return
+	}
+	if native != "" {
+		if fn != nil {
+			err = fmt.Errorf("reeed: rule %s in group %s has both a rule function and a native kind", name, parent.name)
+			return
+		}
+		return newNativeRule(native, name, newParams(params, path))
+	} else if fn == nil {
+		err = fmt.Errorf("reeed: missing rule function: rule %s in group %s", name, parent.name)
+		return
+	}
+	rule = &starRule{
+		parent: parent,
+		name:   name,
+		fn:     fn,
+	}
+	return
+}
+
+type starRule struct {
+	parent *group
+	name   string
+	fn     starlark.Callable
+}
+
+func (r *starRule) String() string {
+	return r.name
+}
+
+func (r *starRule) Eval(ctx context.Context, logger log.Printer, msg *daemon.Message, tagger daemon.Tagger) (match bool, err error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	prefix := "[" + r.parent.name + "." + r.name + "] "
+	thread := &starlark.Thread{
+		Name: r.parent.name + "." + r.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			logger.Print(log.VerboseLevel, prefix+msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(starMaxExecutionSteps)
+	go func() {
+		<-timeoutCtx.Done()
+		thread.Cancel(timeoutCtx.Err().Error())
+	}()
+
+	m := newStarMessage(msg, tagger)
+	l := newStarLogger(prefix, logger)
+
+	v, err := starlark.Call(thread, r.fn, starlark.Tuple{m, l}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	match = bool(v.Truth())
+	return
+}
+
+func starElems(list *starlark.List) []starlark.Value {
+	elems := make([]starlark.Value, list.Len())
+	for i := range elems {
+		elems[i] = list.Index(i)
+	}
+	return elems
+}
+
+func fromStarValue(v starlark.Value) (any, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return nil, fmt.Errorf("integer out of range: %s", v)
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case *starlark.List:
+		return fromStarElems(starElems(v))
+	case starlark.Tuple:
+		return fromStarElems(v)
+	case *starlark.Dict:
+		m := make(map[string]any, v.Len())
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict key must be a string, but it is a %s", item[0].Type())
+			}
+			value, err := fromStarValue(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %s", v.Type())
+	}
+}
+
+func fromStarElems(elems []starlark.Value) ([]any, error) {
+	list := make([]any, len(elems))
+	for i := range elems {
+		var err error
+		list[i], err = fromStarValue(elems[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+/**
+def rule(msg, logger):
+    logger.log("subject: %s", msg.subject)
+    return msg.subject == "hello"
+
+add_rules({
+    "foo": [
+        {"name": "bar", "rule": rule},
+    ],
+    "baz": {
+        "onError": "skip",
+        "rules": [...],
+    },
+})
+*/
@@ -0,0 +1,336 @@
+package rule
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/gogama/reee-evolution/daemon"
+	"github.com/gogama/reee-evolution/log"
+	"github.com/jhillyerd/enmime"
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// starMessage is the Starlark counterpart of jsMessage. Attribute
+// names follow Starlark convention, so for example the JavaScript
+// property replyTo is reply_to in Starlark. Because from is a reserved
+// word in Starlark, the From mailboxes are in the attribute from_.
+type starMessage struct {
+	msg    *daemon.Message
+	tagger daemon.Tagger
+	cache  map[string]starlark.Value
+}
+
+var starMessageMailboxHeaderAttrs = map[string]string{
+	"from_":    "From",
+	"sender":   "Sender",
+	"reply_to": "Reply-To",
+	"to":       "To",
+	"cc":       "Cc",
+	"bcc":      "Bcc",
+}
+
+var starMessageAttrNames = []string{
+	"attachments", "bcc", "calendar", "cc", "date", "from_", "headers",
+	"html", "id", "reply_to", "sender", "subject", "tags", "text", "to",
+}
+
+func newStarMessage(msg *daemon.Message, tagger daemon.Tagger) *starMessage {
+	return &starMessage{
+		msg:    msg,
+		tagger: tagger,
+		cache:  make(map[string]starlark.Value),
+	}
+}
+
+func (m *starMessage) String() string        { return "<message>" }
+func (m *starMessage) Type() string          { return "message" }
+func (m *starMessage) Freeze()               {}
+func (m *starMessage) Truth() starlark.Bool  { return starlark.True }
+func (m *starMessage) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: message") }
+func (m *starMessage) AttrNames() []string   { return starMessageAttrNames }
+
+func (m *starMessage) Attr(name string) (starlark.Value, error) {
+	if v, ok := m.cache[name]; ok {
+		return v, nil
+	}
+	v, err := m.attr(name)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	m.cache[name] = v
+	return v, nil
+}
+
+func (m *starMessage) attr(name string) (starlark.Value, error) {
+	e := m.msg.Envelope
+	if headerName, ok := starMessageMailboxHeaderAttrs[name]; ok {
+		return toStarAddresses(e.GetHeader(headerName)), nil
+	}
+	switch name {
+	case "id":
+		return starlark.String(e.GetHeader("Message-Id")), nil
+	case "subject":
+		return starlark.String(e.GetHeader("Subject")), nil
+	case "text":
+		return starlark.String(e.Text), nil
+	case "html":
+		return starlark.String(e.HTML), nil
+	case "date":
+		t, err := mail.ParseDate(e.GetHeader("Date"))
+		if err != nil {
+			return starlark.None, nil
+		}
+		return starlarktime.Time(t), nil
+	case "headers":
+		return &starMap{name: "headers", i: headersMap{Envelope: e}, multi: headersMap{Envelope: e}}, nil
+	case "tags":
+		if m.tagger == nil {
+			return starlark.None, nil
+		}
+		tm := tagsMap{Tagger: m.tagger}
+		return &starMap{name: "tags", i: tm, mut: tm}, nil
+	case "attachments":
+		return toStarAttachments(e.Attachments), nil
+	case "calendar":
+		calendar, err := parseCalendar(e.OtherParts, e.Inlines)
+		if calendar == nil && err == nil {
+			return starlark.None, nil
+		}
+		return toStarCalendar(calendar, err), nil
+	}
+	return nil, nil
+}
+
+// starMap is the Starlark counterpart of jsLazyMap.
+type starMap struct {
+	name  string
+	i     immutableMap
+	multi multiMap
+	mut   mutableMap
+}
+
+func (sm *starMap) String() string        { return "<" + sm.name + ">" }
+func (sm *starMap) Type() string          { return sm.name }
+func (sm *starMap) Freeze()               {}
+func (sm *starMap) Truth() starlark.Bool  { return starlark.True }
+func (sm *starMap) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", sm.name) }
+
+func (sm *starMap) AttrNames() []string {
+	names := []string{"get", "keys"}
+	if sm.multi != nil {
+		names = append(names, "get_all")
+	}
+	if sm.mut != nil {
+		names = append(names, "delete_key", "set")
+	}
+	return names
+}
+
+func (sm *starMap) Attr(name string) (starlark.Value, error) {
+	switch {
+	case name == "keys":
+		return toStarStrings(sm.i.keys()), nil
+	case name == "get":
+		return starlark.NewBuiltin(name, sm.get).BindReceiver(sm), nil
+	case name == "get_all" && sm.multi != nil:
+		return starlark.NewBuiltin(name, sm.getAll).BindReceiver(sm), nil
+	case name == "set" && sm.mut != nil:
+		return starlark.NewBuiltin(name, sm.set).BindReceiver(sm), nil
+	case name == "delete_key" && sm.mut != nil:
+		return starlark.NewBuiltin(name, sm.deleteKey).BindReceiver(sm), nil
+	}
+	return nil, nil
+}
+
+func (sm *starMap) get(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &key); err != nil {
+		return nil, err
+	}
+	if value, ok := sm.i.get(key); ok {
+		return starlark.String(value), nil
+	}
+	return starlark.None, nil
+}
+
+func (sm *starMap) getAll(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &key); err != nil {
+		return nil, err
+	}
+	if values, ok := sm.multi.getAll(key); ok {
+		return toStarStrings(values), nil
+	}
+	return starlark.None, nil
+}
+
+func (sm *starMap) set(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key, value string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &key, &value); err != nil {
+		return nil, err
+	}
+	sm.mut.set(key, value)
+	return starlark.String(value), nil
+}
+
+func (sm *starMap) deleteKey(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &key); err != nil {
+		return nil, err
+	}
+	sm.mut.deleteKey(key)
+	return starlark.None, nil
+}
+
+func newStarLogger(prefix string, logger log.Printer) starlark.Value {
+	logFunc := func(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(args) < 1 || len(kwargs) > 0 {
+			return nil, fmt.Errorf("%s: must receive at least one positional argument", fn.Name())
+		}
+		msg := args[0]
+		if len(args) > 1 {
+			var err error
+			msg, err = starlark.Binary(syntax.PERCENT, msg, args[1:])
+			if err != nil {
+				return nil, err
+			}
+		}
+		s, ok := starlark.AsString(msg)
+		if !ok {
+			s = msg.String()
+		}
+		logger.Print(log.VerboseLevel, prefix+s)
+		return starlark.None, nil
+	}
+	return starlarkstruct.FromStringDict(starlark.String("logger"), starlark.StringDict{
+		"log": starlark.NewBuiltin("log", logFunc),
+	})
+}
+
+func toStarStrings(list []string) *starlark.List {
+	elems := make([]starlark.Value, len(list))
+	for i := range list {
+		elems[i] = starlark.String(list[i])
+	}
+	return starlark.NewList(elems)
+}
+
+func toStarOptionalString(s string) starlark.Value {
+	if s == "" {
+		return starlark.None
+	}
+	return starlark.String(s)
+}
+
+func toStarOptionalTime(t time.Time) starlark.Value {
+	if t.IsZero() {
+		return starlark.None
+	}
+	return starlarktime.Time(t)
+}
+
+func toStarAddresses(addresses string) starlark.Value {
+	if addresses == "" {
+		return starlark.None
+	}
+	list, err := mail.ParseAddressList(addresses)
+	if err != nil {
+		// TODO: Find a way to log this and just continue.
+		list = nil
+	}
+	elems := make([]starlark.Value, len(list))
+	for i := range list {
+		elems[i] = toStarMailbox(list[i])
+	}
+	return starlark.NewList(elems)
+}
+
+func toStarMailbox(mailbox *mail.Address) starlark.Value {
+	address, localPart, domain := starlark.Value(starlark.None), starlark.Value(starlark.None), starlark.Value(starlark.None)
+	if mailbox.Address != "" {
+		address = starlark.String(mailbox.Address)
+		if i := strings.IndexByte(mailbox.Address, '@'); i >= 0 {
+			localPart = starlark.String(mailbox.Address[0:i])
+			domain = starlark.String(mailbox.Address[i+1:])
+		}
+	}
+	return starlarkstruct.FromStringDict(starlark.String("mailbox"), starlark.StringDict{
+		"name":       toStarOptionalString(mailbox.Name),
+		"address":    address,
+		"local_part": localPart,
+		"domain":     domain,
+	})
+}
+
+func toStarAttachments(attachments []*enmime.Part) starlark.Value {
+	elems := make([]starlark.Value, len(attachments))
+	for i, part := range attachments {
+		elems[i] = starlarkstruct.FromStringDict(starlark.String("attachment"), starlark.StringDict{
+			"file_name":     starlark.String(part.FileName),
+			"file_mod_date": toStarOptionalTime(part.FileModDate),
+			"content_type":  starlark.String(part.ContentType),
+		})
+	}
+	return starlark.NewList(elems)
+}
+
+// toStarCalendar converts a calendar to a struct. Like the JavaScript
+// calendar, a calendar which can't be parsed has no events, and its
+// parse_error attribute holds the error.
+func toStarCalendar(calendar *ics.Calendar, parseErr error) starlark.Value {
+	parseError := starlark.Value(starlark.None)
+	var list []*ics.VEvent
+	if parseErr != nil {
+		parseError = starlark.String(parseErr.Error())
+	} else {
+		list = calendar.Events()
+	}
+	events := make([]starlark.Value, len(list))
+	for i, event := range list {
+		summary := starlark.Value(starlark.None)
+		if value := event.GetProperty(ics.ComponentProperty(ics.PropertySummary)); value != nil {
+			summary = starlark.String(value.Value)
+		}
+		attendees := event.Attendees()
+		elems := make([]starlark.Value, len(attendees))
+		for j, attendee := range attendees {
+			elems[j] = toStarCalendarAttendee(attendee)
+		}
+		events[i] = starlarkstruct.FromStringDict(starlark.String("calendar_event"), starlark.StringDict{
+			"summary":   summary,
+			"attendees": starlark.NewList(elems),
+		})
+	}
+	return starlarkstruct.FromStringDict(starlark.String("calendar"), starlark.StringDict{
+		"events":      starlark.NewList(events),
+		"parse_error": parseError,
+	})
+}
+
+func toStarCalendarAttendee(attendee *ics.Attendee) starlark.Value {
+	email := attendee.Value
+	if strings.HasPrefix(email, "MAILTO:") || strings.HasPrefix(email, "mailto:") {
+		email = email[7:]
+	}
+	address := mail.Address{
+		Address: email,
+	}
+	if list := attendee.ICalParameters[string(ics.ParameterCn)]; len(list) > 0 {
+		address.Name = list[0]
+	}
+	role := starlark.Value(starlark.None)
+	if list := attendee.ICalParameters[string(ics.ParameterRole)]; len(list) > 0 {
+		role = starlark.String(list[0])
+	}
+	return starlarkstruct.FromStringDict(starlark.String("calendar_attendee"), starlark.StringDict{
+		"mailbox":              toStarMailbox(&address),
+		"role":                 role,
+		"participation_status": toStarOptionalString(string(attendee.ParticipationStatus())),
+	})
+}
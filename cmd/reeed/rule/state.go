@@ -0,0 +1,186 @@
+package rule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/gogama/reee-evolution/daemon"
+)
+
+// stateNamespace returns the state namespace of a rule file, which is
+// its path relative to the rule directory.
+func stateNamespace(set *GroupSet, path string) string {
+	if set.Dir != "" {
+		if rel, err := filepath.Rel(set.Dir, path); err == nil {
+			path = rel
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// installStateFunc makes the persistent rule state available to
+// JavaScript as reee.state(msg). Values are stored as JSON. State is
+// bound to the evaluation of msg, and changes only take effect if the
+// evaluation succeeds.
+func installStateFunc(set *GroupSet, cont *vmContainer, reeeObject *goja.Object) error {
+	vm := cont.vm
+	namespace := stateNamespace(set, cont.path)
+	return reeeObject.Set("state", vm.ToValue(func(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+		m, ok := call.Argument(0).Export().(*jsMessage)
+		if !ok {
+			throwJSException(vm, errUnexpectedArgType(0, m, call.Argument(0).Export()))
+		}
+		v, err := marshalState(cont, m, namespace)
+		if err != nil {
+			throwJSException(vm, err)
+		}
+		return v
+	}))
+}
+
+type jsState struct {
+	state     daemon.State
+	namespace string
+}
+
+func marshalState(cont *vmContainer, m *jsMessage, namespace string) (goja.Value, error) {
+	if m.state != nil {
+		return m.state, nil
+	}
+	state, ok := m.evalCtx.(daemon.State)
+	if !ok {
+		return nil, errors.New("reeed: state() may only be called while evaluating a rule")
+	}
+	if cont.stateProto == nil {
+		proto, err := jsStatePrototype(cont.vm)
+		if err != nil {
+			return nil, err
+		}
+		cont.stateProto = proto
+	}
+	s := &jsState{
+		state:     state,
+		namespace: namespace,
+	}
+	o := cont.vm.ToValue(s).ToObject(cont.vm)
+	err := o.SetPrototype(cont.stateProto)
+	if err != nil {
+		return nil, err
+	}
+	m.state = o
+	return o, nil
+}
+
+func jsStatePrototype(vm *goja.Runtime) (*goja.Object, error) {
+	proto := vm.NewObject()
+	methods := []struct {
+		name string
+		f    func(call goja.FunctionCall, vm *goja.Runtime) goja.Value
+	}{
+		{"get", jsStateGet},
+		{"set", jsStateSet},
+		{"incr", jsStateIncr},
+		{"delete", jsStateDelete},
+	}
+	for _, m := range methods {
+		err := proto.Set(m.name, vm.ToValue(m.f))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proto, nil
+}
+
+func jsStateThis(call goja.FunctionCall, vm *goja.Runtime) *jsState {
+	s, ok := call.This.Export().(*jsState)
+	if !ok {
+		throwJSException(vm, errUnexpectedThisType(s, call.This.Export()))
+	}
+	return s
+}
+
+func (s *jsState) get(vm *goja.Runtime, key string) (any, bool) {
+	text, hit, err := s.state.GetState(s.namespace, key)
+	if err != nil {
+		throwJSException(vm, fmt.Sprintf("reeed: can't get state %s: %s", key, err))
+	}
+	if !hit {
+		return nil, false
+	}
+	var value any
+	if err = json.Unmarshal([]byte(text), &value); err != nil {
+		throwJSException(vm, fmt.Sprintf("reeed: invalid state %s: %s", key, err))
+	}
+	return value, true
+}
+
+func jsStateGet(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	value, hit := jsStateThis(call, vm).get(vm, call.Argument(0).String())
+	if !hit {
+		return goja.Null()
+	}
+	return vm.ToValue(value)
+}
+
+func jsStateSet(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	s := jsStateThis(call, vm)
+	if len(call.Arguments) < 2 {
+		throwJSException(vm, "reeed: state.set() requires at least 2 arguments")
+	}
+	key := call.Argument(0).String()
+	b, err := json.Marshal(call.Argument(1).Export())
+	if err != nil {
+		throwJSException(vm, fmt.Sprintf("reeed: can't set state %s: %s", key, err))
+	}
+	s.state.SetState(s.namespace, key, string(b), unmarshalTTL(vm, call.Argument(2)))
+	return goja.Undefined()
+}
+
+func jsStateIncr(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	s := jsStateThis(call, vm)
+	key := call.Argument(0).String()
+	delta := float64(1)
+	if d := call.Argument(1); !goja.IsUndefined(d) && !goja.IsNull(d) {
+		delta = d.ToFloat()
+	}
+	n := float64(0)
+	if value, hit := s.get(vm, key); hit {
+		var ok bool
+		if n, ok = value.(float64); !ok {
+			throwJSException(vm, fmt.Sprintf("reeed: can't increment state %s: value is not a number", key))
+		}
+	}
+	n += delta
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		throwJSException(vm, fmt.Sprintf("reeed: can't increment state %s: result is not finite", key))
+	}
+	// The increment is recorded as a delta, so that concurrent
+	// evaluations don't overwrite each other's increments. The result
+	// returned is the value as seen by this evaluation.
+	s.state.IncrState(s.namespace, key, delta, unmarshalTTL(vm, call.Argument(2)))
+	return vm.ToValue(n)
+}
+
+func jsStateDelete(call goja.FunctionCall, vm *goja.Runtime) goja.Value {
+	s := jsStateThis(call, vm)
+	s.state.DeleteState(s.namespace, call.Argument(0).String())
+	return goja.Undefined()
+}
+
+// unmarshalTTL converts an optional time-to-live in seconds. A missing
+// or non-positive TTL means the key doesn't expire.
+func unmarshalTTL(vm *goja.Runtime, v goja.Value) time.Duration {
+	if goja.IsUndefined(v) || goja.IsNull(v) {
+		return 0
+	}
+	seconds := v.ToFloat()
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		throwJSException(vm, fmt.Sprintf("reeed: invalid state TTL: %s", v))
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
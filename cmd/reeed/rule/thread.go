@@ -0,0 +1,140 @@
+package rule
+
+import (
+	"net/mail"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/gogama/reee-evolution/daemon"
+)
+
+// jsStoredMessage is a read-only view of a message from the message
+// store, such as the parent of the message being evaluated.
+type jsStoredMessage struct {
+	msg daemon.StoredMessage
+
+	id          goja.Value // string
+	date        goja.Value // Date
+	seen        goja.Value // Date
+	from        goja.Value // mailbox
+	to          goja.Value // string
+	subject     goja.Value // string
+	inReplyTo   goja.Value // string
+	threadTopic goja.Value // string
+	tags        goja.Value // read-only lazy map
+}
+
+func marshalParent(cont *vmContainer, msg *jsMessage) (goja.Value, error) {
+	history, ok := msg.tagger.(daemon.History)
+	if !ok {
+		return goja.Null(), nil
+	}
+	parent, hit, err := history.Parent()
+	if err != nil {
+		return nil, err
+	} else if !hit {
+		return goja.Null(), nil
+	}
+	return marshalStoredMessage(cont, parent)
+}
+
+func marshalThread(cont *vmContainer, msg *jsMessage) (goja.Value, error) {
+	history, ok := msg.tagger.(daemon.History)
+	if !ok {
+		return cont.vm.NewArray(), nil
+	}
+	thread, err := history.Thread()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, len(thread))
+	for i := range thread {
+		values[i], err = marshalStoredMessage(cont, thread[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cont.vm.NewArray(values...), nil
+}
+
+func marshalStoredMessage(cont *vmContainer, msg daemon.StoredMessage) (goja.Value, error) {
+	if cont.storedMessageProto == nil {
+		proto, err := jsStoredMessagePrototype(cont)
+		if err != nil {
+			return nil, err
+		}
+		cont.storedMessageProto = proto
+	}
+	m := &jsStoredMessage{
+		msg: msg,
+	}
+	o := cont.vm.ToValue(m).ToObject(cont.vm)
+	err := o.SetPrototype(cont.storedMessageProto)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func jsStoredMessagePrototype(cont *vmContainer) (*goja.Object, error) {
+	vm := cont.vm
+	proto := vm.NewObject()
+	stringProps := []struct {
+		propName string
+		get      func(*daemon.StoredMessage) string
+	}{
+		{"id", func(msg *daemon.StoredMessage) string { return msg.MessageID() }},
+		{"to", func(msg *daemon.StoredMessage) string { return msg.ToAddress }},
+		{"subject", func(msg *daemon.StoredMessage) string { return msg.Subject }},
+		{"inReplyTo", func(msg *daemon.StoredMessage) string { return msg.InReplyTo }},
+		{"threadTopic", func(msg *daemon.StoredMessage) string { return msg.ThreadTopic }},
+	}
+	for _, prop := range stringProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(m *jsStoredMessage) (goja.Value, error) {
+			if value := get(&m.msg); value != "" {
+				return vm.ToValue(value), nil
+			}
+			return goja.Null(), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	timeProps := []struct {
+		propName string
+		get      func(*daemon.StoredMessage) time.Time
+	}{
+		{"date", func(msg *daemon.StoredMessage) time.Time { return msg.SendTime }},
+		{"seen", func(msg *daemon.StoredMessage) time.Time { return msg.InsertTime }},
+	}
+	for _, prop := range timeProps {
+		get := prop.get
+		err := defineCachedProperty(vm, proto, prop.propName, func(m *jsStoredMessage) (goja.Value, error) {
+			t := get(&m.msg)
+			if t.IsZero() {
+				return goja.Null(), nil
+			}
+			return marshalDate(vm, t)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := defineCachedProperty(vm, proto, "from", func(m *jsStoredMessage) (goja.Value, error) {
+		if m.msg.FromAddress == "" {
+			return goja.Null(), nil
+		}
+		return marshalMailbox(cont, &mail.Address{Name: m.msg.FromName, Address: m.msg.FromAddress})
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "tags", func(m *jsStoredMessage) (goja.Value, error) {
+		return marshalLazyMap(vm, &cont.storedTagsProto, storedTagsMap(m.msg.Tags), nil, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
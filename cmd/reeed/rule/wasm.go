@@ -0,0 +1,341 @@
+package rule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogama/reee-evolution/daemon"
+	"github.com/gogama/reee-evolution/log"
+	"github.com/gogama/reee-evolution/protocol"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WebAssembly rule modules are loaded from files named
+// <group>.<rule>.wasm. Each evaluation gets a fresh instance of the
+// module, so no state carries over from one evaluation to the next.
+//
+// The module must export its memory as "memory", and two functions:
+//
+//	reee_alloc(size i32) -> i32
+//	reee_eval(ptr i32, len i32) -> i64
+//
+// The host calls reee_alloc to obtain a buffer of size bytes in module
+// memory, writes the JSON-encoded message into it, and then calls
+// reee_eval with the buffer location. The message has the form:
+//
+//	{
+//	  "id": "...", "subject": "...", "text": "...", "html": "...",
+//	  "from": [{"name": "...", "address": "..."}], "sender": [...],
+//	  "replyTo": [...], "to": [...], "cc": [...], "bcc": [...],
+//	  "headers": {"Name": ["value", ...]},
+//	  "attachments": [{"fileName": "...", "contentType": "...", "size": 0}],
+//	  "tags": {"key": "value"}
+//	}
+//
+// reee_eval returns the location of a JSON-encoded result in module
+// memory, packed as (ptr << 32) | len. The result has the form:
+//
+//	{"match": true, "score": 0.5, "setTags": {"key": "value"}, "deleteTags": ["key"]}
+//
+// All result fields are optional. The score, if given, is logged.
+//
+// Modules may import WASI, which is sandboxed with no file system,
+// environment or arguments, and the host function reee.log(ptr i32,
+// len i32), which logs a UTF-8 string from module memory at verbose
+// level.
+//
+// Memory is limited to wasmMemoryLimitPages. Modules are metered when
+// they are loaded, and each evaluation, including the module's
+// _initialize function, has wasmFuel units of fuel, roughly one per
+// instruction executed. An evaluation which runs out of fuel fails. The
+// module's exports may not include the name "reee_fuel", which is used
+// by the metering.
+const (
+	wasmMemoryLimitPages = 256 // 16 MiB
+	wasmFuel             = 100_000_000
+)
+
+type wasmLoggerKey struct{}
+
+func (set *GroupSet) wasmRuntime(ctx context.Context) (wazero.Runtime, error) {
+	if set.wasm != nil {
+		return set.wasm, nil
+	}
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(wasmMemoryLimitPages).
+		WithCloseOnContextDone(true)
+	r := wazero.NewRuntimeWithConfig(ctx, config)
+	_, err := wasi_snapshot_preview1.Instantiate(ctx, r)
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, err
+	}
+	_, err = r.NewHostModuleBuilder("reee").
+		NewFunctionBuilder().
+		WithFunc(wasmLog).
+		WithParameterNames("ptr", "len").
+		Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, err
+	}
+	set.wasm = r
+	return r, nil
+}
+
+func wasmLog(ctx context.Context, m api.Module, ptr, n uint32) {
+	logger, ok := ctx.Value(wasmLoggerKey{}).(*jsLogger)
+	if !ok {
+		return
+	}
+	b, ok := m.Memory().Read(ptr, n)
+	if !ok {
+		return
+	}
+	logger.logger.Print(log.VerboseLevel, logger.prefix+string(b))
+}
+
+// LoadWasm loads a WebAssembly rule module.
+func (set *GroupSet) LoadWasm(ctx context.Context, logger log.Printer, path string) error {
+	start := time.Now()
+
+	base := strings.TrimSuffix(filepath.Base(path), ".wasm")
+	parts := strings.Split(base, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("reeed: WebAssembly rule file name must have the form <group>.<rule>.wasm: %s", path)
+	}
+	g, name := parts[0], parts[1]
+	if err := protocol.ValidateName("group name", g); err != nil {
+		return fmt.Errorf("reeed: %s: %s", path, err)
+	}
+	if err := protocol.ValidateName("rule name", name); err != nil {
+		return fmt.Errorf("reeed: %s: %s", path, err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	b, err = meterWasm(b, wasmFuel)
+	if err != nil {
+		return fmt.Errorf("reeed: can't meter %s: %s", path, err)
+	}
+
+	runtime, err := set.wasmRuntime(ctx)
+	if err != nil {
+		return err
+	}
+	compiled, err := runtime.CompileModule(ctx, b)
+	if err != nil {
+		return fmt.Errorf("reeed: can't compile %s: %s", path, err)
+	}
+	exports := compiled.ExportedFunctions()
+	for _, export := range []string{"reee_alloc", "reee_eval"} {
+		if exports[export] == nil {
+			_ = compiled.Close(ctx)
+			return fmt.Errorf("reeed: %s does not export function %s", path, export)
+		}
+	}
+	if compiled.ExportedMemories()["memory"] == nil {
+		_ = compiled.Close(ctx)
+		return fmt.Errorf("reeed: %s does not export memory", path)
+	}
+
+	group := set.group(g)
+	err = group.addRule(&wasmRule{
+		parent:   group,
+		path:     path,
+		name:     name,
+		runtime:  runtime,
+		compiled: compiled,
+	}, path)
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(start)
+	log.Verbose(logger, "loaded 1 groups and 1 rules from %s in %s.", path, elapsed)
+	return nil
+}
+
+type wasmRule struct {
+	parent   *group
+	path     string
+	name     string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+func (r *wasmRule) String() string {
+	return r.name
+}
+
+func (r *wasmRule) Eval(ctx context.Context, logger log.Printer, msg *daemon.Message, tagger daemon.Tagger) (match bool, err error) {
+	l := &jsLogger{
+		prefix: "[" + r.parent.name + "." + r.name + "] ",
+		logger: logger,
+	}
+	modCtx := context.WithValue(ctx, wasmLoggerKey{}, l)
+
+	input, err := json.Marshal(newWasmInput(msg, tagger))
+	if err != nil {
+		return false, err
+	}
+
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize")
+	mod, err := r.runtime.InstantiateModule(modCtx, r.compiled, config)
+	if err != nil {
+		return false, r.wrapErr(ctx, nil, err)
+	}
+	defer func() {
+		_ = mod.Close(context.Background())
+	}()
+
+	results, err := mod.ExportedFunction("reee_alloc").Call(modCtx, uint64(len(input)))
+	if err != nil {
+		return false, r.wrapErr(ctx, mod, err)
+	}
+	ptr := uint32(results[0])
+	if !mod.Memory().Write(ptr, input) {
+		return false, fmt.Errorf("reeed: %s: reee_alloc returned out of range buffer", r.path)
+	}
+
+	results, err = mod.ExportedFunction("reee_eval").Call(modCtx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return false, r.wrapErr(ctx, mod, err)
+	}
+	out, ok := mod.Memory().Read(uint32(results[0]>>32), uint32(results[0]))
+	if !ok {
+		return false, fmt.Errorf("reeed: %s: reee_eval returned out of range result", r.path)
+	}
+
+	var result wasmResult
+	err = json.Unmarshal(out, &result)
+	if err != nil {
+		return false, fmt.Errorf("reeed: %s: invalid result: %s", r.path, err)
+	}
+	if result.Score != nil {
+		logger.Print(log.VerboseLevel, fmt.Sprintf("%sscore: %g", l.prefix, *result.Score))
+	}
+	keys := make([]string, 0, len(result.SetTags))
+	for key := range result.SetTags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tagger.SetTag(key, result.SetTags[key])
+	}
+	for _, key := range result.DeleteTags {
+		tagger.DeleteTag(key)
+	}
+
+	return result.Match, nil
+}
+
+func (r *wasmRule) wrapErr(ctx context.Context, mod api.Module, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("reeed: %s: terminated: %s", r.path, ctxErr)
+	}
+	if mod != nil {
+		if fuel := mod.ExportedGlobal(wasmFuelExport); fuel != nil && int64(fuel.Get()) < 0 {
+			return fmt.Errorf("reeed: %s: terminated: out of fuel", r.path)
+		}
+	}
+	return fmt.Errorf("reeed: %s: %s", r.path, err)
+}
+
+type wasmInput struct {
+	ID          string              `json:"id"`
+	Subject     string              `json:"subject"`
+	Text        string              `json:"text"`
+	HTML        string              `json:"html"`
+	From        []wasmMailbox       `json:"from"`
+	Sender      []wasmMailbox       `json:"sender"`
+	ReplyTo     []wasmMailbox       `json:"replyTo"`
+	To          []wasmMailbox       `json:"to"`
+	Cc          []wasmMailbox       `json:"cc"`
+	Bcc         []wasmMailbox       `json:"bcc"`
+	Headers     map[string][]string `json:"headers"`
+	Attachments []wasmAttachment    `json:"attachments"`
+	Tags        map[string]string   `json:"tags"`
+}
+
+type wasmMailbox struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+type wasmAttachment struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int    `json:"size"`
+}
+
+type wasmResult struct {
+	Match      bool              `json:"match"`
+	Score      *float64          `json:"score"`
+	SetTags    map[string]string `json:"setTags"`
+	DeleteTags []string          `json:"deleteTags"`
+}
+
+func newWasmInput(msg *daemon.Message, tagger daemon.Tagger) *wasmInput {
+	e := msg.Envelope
+	in := &wasmInput{
+		ID:      e.GetHeader("Message-Id"),
+		Subject: e.GetHeader("Subject"),
+		Text:    e.Text,
+		HTML:    e.HTML,
+		From:    wasmMailboxes(msg, "From"),
+		Sender:  wasmMailboxes(msg, "Sender"),
+		ReplyTo: wasmMailboxes(msg, "Reply-To"),
+		To:      wasmMailboxes(msg, "To"),
+		Cc:      wasmMailboxes(msg, "Cc"),
+		Bcc:     wasmMailboxes(msg, "Bcc"),
+		Headers: make(map[string][]string),
+		Tags:    make(map[string]string),
+	}
+	for _, key := range e.GetHeaderKeys() {
+		in.Headers[key] = e.GetHeaderValues(key)
+	}
+	in.Attachments = make([]wasmAttachment, len(e.Attachments))
+	for i, part := range e.Attachments {
+		in.Attachments[i] = wasmAttachment{
+			FileName:    part.FileName,
+			ContentType: part.ContentType,
+			Size:        len(part.Content),
+		}
+	}
+	for _, key := range tagger.Keys() {
+		if value, ok := tagger.GetTag(key); ok {
+			in.Tags[key] = value
+		}
+	}
+	return in
+}
+
+func wasmMailboxes(msg *daemon.Message, headerName string) []wasmMailbox {
+	list, err := msg.Envelope.AddressList(headerName)
+	if err != nil {
+		return nil
+	}
+	mailboxes := make([]wasmMailbox, len(list))
+	for i := range list {
+		mailboxes[i] = wasmMailbox{
+			Name:    list[i].Name,
+			Address: list[i].Address,
+		}
+	}
+	return mailboxes
+}
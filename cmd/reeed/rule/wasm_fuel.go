@@ -0,0 +1,515 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+)
+
+// wasmFuelExport is the name of the global which meterWasm adds to a
+// WebAssembly module to hold its remaining fuel.
+const wasmFuelExport = "reee_fuel"
+
+// wasmSectionOrder gives the required order of the non-custom sections
+// of a WebAssembly module, keyed by section ID.
+var wasmSectionOrder = map[byte]int{
+	1:  1,  // Type
+	2:  2,  // Import
+	3:  3,  // Function
+	4:  4,  // Table
+	5:  5,  // Memory
+	13: 6,  // Tag
+	6:  7,  // Global
+	7:  8,  // Export
+	8:  9,  // Start
+	9:  10, // Element
+	12: 11, // Data count
+	10: 12, // Code
+	11: 13, // Data
+}
+
+const (
+	wasmImportSection = 2
+	wasmGlobalSection = 6
+	wasmExportSection = 7
+	wasmCodeSection   = 10
+)
+
+type wasmSection struct {
+	id      byte
+	payload []byte
+}
+
+var errWasmTruncated = errors.New("unexpected end of module")
+
+// meterWasm instruments a WebAssembly module so that it consumes fuel as
+// it runs, and traps when its fuel runs out. The remaining fuel is kept
+// in a new mutable i64 global, exported as wasmFuelExport, which starts
+// at fuel.
+//
+// Fuel is charged on entry to each function and at the head of each
+// loop, which are the only places where execution can repeat. The
+// charge is the number of instructions from the charging point to the
+// next one in the function body. This is only an estimate of the work
+// done, since branches can skip charged instructions or reach uncharged
+// ones, but it means a module can't run without bound.
+func meterWasm(b []byte, fuel int64) ([]byte, error) {
+	sections, err := splitWasmSections(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fuel global is appended after the imported and defined
+	// globals, so no existing global index changes.
+	var numGlobals uint64
+	for _, s := range sections {
+		switch s.id {
+		case wasmImportSection:
+			n, err := countWasmGlobalImports(s.payload)
+			if err != nil {
+				return nil, fmt.Errorf("import section: %w", err)
+			}
+			numGlobals += n
+		case wasmGlobalSection:
+			n, _, err := readWasmULEB(s.payload, 0)
+			if err != nil {
+				return nil, fmt.Errorf("global section: %w", err)
+			}
+			numGlobals += n
+		}
+	}
+	fuelGlobal := numGlobals
+
+	global := []byte{0x7e, 0x01, 0x42} // mut i64 = i64.const fuel
+	global = appendWasmSLEB(global, fuel)
+	global = append(global, 0x0b)
+	export := appendWasmName(nil, wasmFuelExport)
+	export = append(export, 0x03)
+	export = appendWasmULEB(export, fuelGlobal)
+
+	var hasGlobals, hasExports bool
+	for i := range sections {
+		s := &sections[i]
+		switch s.id {
+		case wasmGlobalSection:
+			hasGlobals = true
+			s.payload, err = appendWasmVec(s.payload, global)
+		case wasmExportSection:
+			hasExports = true
+			if err = checkWasmExportName(s.payload, wasmFuelExport); err == nil {
+				s.payload, err = appendWasmVec(s.payload, export)
+			}
+		case wasmCodeSection:
+			s.payload, err = meterWasmCode(s.payload, fuelGlobal)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !hasGlobals {
+		sections = insertWasmSection(sections, wasmSection{wasmGlobalSection, append([]byte{1}, global...)})
+	}
+	if !hasExports {
+		sections = insertWasmSection(sections, wasmSection{wasmExportSection, append([]byte{1}, export...)})
+	}
+
+	out := append([]byte(nil), b[:8]...)
+	for _, s := range sections {
+		out = append(out, s.id)
+		out = appendWasmULEB(out, uint64(len(s.payload)))
+		out = append(out, s.payload...)
+	}
+	return out, nil
+}
+
+func splitWasmSections(b []byte) ([]wasmSection, error) {
+	if len(b) < 8 || string(b[:4]) != "\x00asm" || string(b[4:8]) != "\x01\x00\x00\x00" {
+		return nil, errors.New("not a version 1 WebAssembly module")
+	}
+	var sections []wasmSection
+	for i := 8; i < len(b); {
+		id := b[i]
+		n, next, err := readWasmULEB(b, i+1)
+		if err != nil {
+			return nil, err
+		}
+		end := next + int(n)
+		if n > uint64(len(b)) || end > len(b) {
+			return nil, errWasmTruncated
+		}
+		sections = append(sections, wasmSection{id, b[next:end]})
+		i = end
+	}
+	return sections, nil
+}
+
+// insertWasmSection inserts a section before the first section which
+// must follow it.
+func insertWasmSection(sections []wasmSection, s wasmSection) []wasmSection {
+	order := wasmSectionOrder[s.id]
+	i := 0
+	for ; i < len(sections); i++ {
+		if o, ok := wasmSectionOrder[sections[i].id]; ok && o > order {
+			break
+		}
+	}
+	sections = append(sections, wasmSection{})
+	copy(sections[i+1:], sections[i:])
+	sections[i] = s
+	return sections
+}
+
+// appendWasmVec appends an element to the encoding of a vector.
+func appendWasmVec(vec []byte, elem []byte) ([]byte, error) {
+	n, next, err := readWasmULEB(vec, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := appendWasmULEB(nil, n+1)
+	out = append(out, vec[next:]...)
+	return append(out, elem...), nil
+}
+
+func countWasmGlobalImports(b []byte) (uint64, error) {
+	n, i, err := readWasmULEB(b, 0)
+	if err != nil {
+		return 0, err
+	}
+	var globals uint64
+	for ; n > 0; n-- {
+		for j := 0; j < 2; j++ { // Module and field names
+			if i, err = skipWasmName(b, i); err != nil {
+				return 0, err
+			}
+		}
+		if i >= len(b) {
+			return 0, errWasmTruncated
+		}
+		kind := b[i]
+		i++
+		switch kind {
+		case 0x00: // Function
+			_, i, err = readWasmULEB(b, i)
+		case 0x01: // Table
+			i, err = skipWasmLimits(b, i+1)
+		case 0x02: // Memory
+			i, err = skipWasmLimits(b, i)
+		case 0x03: // Global
+			globals++
+			i += 2
+		default:
+			err = fmt.Errorf("unknown import kind 0x%02x", kind)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return globals, nil
+}
+
+func checkWasmExportName(b []byte, name string) error {
+	n, i, err := readWasmULEB(b, 0)
+	if err != nil {
+		return err
+	}
+	for ; n > 0; n-- {
+		start := i
+		if i, err = skipWasmName(b, i); err != nil {
+			return err
+		}
+		if string(b[start:i]) == string(appendWasmName(nil, name)) {
+			return fmt.Errorf("module already exports %s", name)
+		}
+		if _, i, err = readWasmULEB(b, i+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func skipWasmName(b []byte, i int) (int, error) {
+	n, i, err := readWasmULEB(b, i)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(b)-i) {
+		return 0, errWasmTruncated
+	}
+	return i + int(n), nil
+}
+
+func skipWasmLimits(b []byte, i int) (int, error) {
+	if i >= len(b) {
+		return 0, errWasmTruncated
+	}
+	flags := b[i]
+	_, i, err := readWasmULEB(b, i+1)
+	if err == nil && flags&1 != 0 {
+		_, i, err = readWasmULEB(b, i)
+	}
+	return i, err
+}
+
+// meterWasmCode instruments each function body in the code section.
+func meterWasmCode(b []byte, fuelGlobal uint64) ([]byte, error) {
+	n, i, err := readWasmULEB(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := appendWasmULEB(nil, n)
+	for f := uint64(0); f < n; f++ {
+		size, start, err := readWasmULEB(b, i)
+		if err != nil {
+			return nil, err
+		}
+		end := start + int(size)
+		if size > uint64(len(b)) || end > len(b) {
+			return nil, errWasmTruncated
+		}
+		body, err := meterWasmFunc(b[start:end], fuelGlobal)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", f, err)
+		}
+		out = appendWasmULEB(out, uint64(len(body)))
+		out = append(out, body...)
+		i = end
+	}
+	return out, nil
+}
+
+func meterWasmFunc(b []byte, fuelGlobal uint64) ([]byte, error) {
+	// Skip the local declarations.
+	n, i, err := readWasmULEB(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	for ; n > 0; n-- {
+		if _, i, err = readWasmULEB(b, i); err != nil {
+			return nil, err
+		}
+		i++
+	}
+	if i > len(b) {
+		return nil, errWasmTruncated
+	}
+
+	// Split the instructions into segments, each starting at a
+	// charging point.
+	type instr struct {
+		start, end int
+	}
+	var instrs []instr
+	segments := []int{0} // Index in instrs of each charging point
+	for j := i; j < len(b); {
+		op := b[j]
+		next, err := skipWasmInstr(b, j)
+		if err != nil {
+			return nil, err
+		}
+		instrs = append(instrs, instr{j, next})
+		if op == 0x03 { // loop
+			segments = append(segments, len(instrs))
+		}
+		j = next
+	}
+	segments = append(segments, len(instrs))
+
+	out := append([]byte(nil), b[:i]...)
+	for s := 0; s+1 < len(segments); s++ {
+		cost := segments[s+1] - segments[s]
+		if cost < 1 {
+			cost = 1
+		}
+		out = appendWasmCharge(out, fuelGlobal, int64(cost))
+		for _, in := range instrs[segments[s]:segments[s+1]] {
+			out = append(out, b[in.start:in.end]...)
+		}
+	}
+	return out, nil
+}
+
+// appendWasmCharge appends code which subtracts cost from the fuel
+// global and traps if the result is negative.
+func appendWasmCharge(out []byte, fuelGlobal uint64, cost int64) []byte {
+	out = append(out, 0x23) // global.get
+	out = appendWasmULEB(out, fuelGlobal)
+	out = append(out, 0x42) // i64.const
+	out = appendWasmSLEB(out, cost)
+	out = append(out, 0x7d, 0x24) // i64.sub, global.set
+	out = appendWasmULEB(out, fuelGlobal)
+	out = append(out, 0x23) // global.get
+	out = appendWasmULEB(out, fuelGlobal)
+	return append(out,
+		0x42, 0x00, // i64.const 0
+		0x53,       // i64.lt_s
+		0x04, 0x40, // if
+		0x00, // unreachable
+		0x0b, // end
+	)
+}
+
+// skipWasmInstr returns the offset of the instruction after the one at
+// offset i.
+func skipWasmInstr(b []byte, i int) (int, error) {
+	if i >= len(b) {
+		return 0, errWasmTruncated
+	}
+	op := b[i]
+	i++
+	var err error
+	switch {
+	case op == 0x00 || op == 0x01 || op == 0x05 || op == 0x0b || op == 0x0f || // unreachable, nop, else, end, return
+		op == 0x1a || op == 0x1b || op == 0xd1 || // drop, select, ref.is_null
+		0x45 <= op && op <= 0xc4: // Numeric
+	case op == 0x02 || op == 0x03 || op == 0x04: // block, loop, if
+		i, err = skipWasmBlockType(b, i)
+	case op == 0x0c || op == 0x0d || op == 0x10 || op == 0x12 || // br, br_if, call, return_call
+		0x20 <= op && op <= 0x26 || op == 0xd2: // Variables, table.get/set, ref.func
+		_, i, err = readWasmULEB(b, i)
+	case op == 0x11 || op == 0x13: // call_indirect, return_call_indirect
+		i, err = skipWasmULEBs(b, i, 2)
+	case op == 0x0e: // br_table
+		var n uint64
+		if n, i, err = readWasmULEB(b, i); err == nil {
+			i, err = skipWasmULEBs(b, i, n+1)
+		}
+	case op == 0x1c: // select t*
+		var n uint64
+		if n, i, err = readWasmULEB(b, i); err == nil {
+			i += int(n)
+		}
+	case 0x28 <= op && op <= 0x3e: // Loads and stores
+		i, err = skipWasmULEBs(b, i, 2)
+	case op == 0x3f || op == 0x40 || op == 0xd0: // memory.size, memory.grow, ref.null
+		i++
+	case op == 0x41 || op == 0x42: // i32.const, i64.const
+		_, i, err = readWasmULEB(b, i)
+	case op == 0x43: // f32.const
+		i += 4
+	case op == 0x44: // f64.const
+		i += 8
+	case op == 0xfc:
+		i, err = skipWasmMiscInstr(b, i)
+	case op == 0xfd:
+		i, err = skipWasmVectorInstr(b, i)
+	default:
+		err = fmt.Errorf("unsupported instruction 0x%02x", op)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if i > len(b) {
+		return 0, errWasmTruncated
+	}
+	return i, nil
+}
+
+func skipWasmBlockType(b []byte, i int) (int, error) {
+	if i >= len(b) {
+		return 0, errWasmTruncated
+	}
+	switch b[i] {
+	case 0x40, 0x7f, 0x7e, 0x7d, 0x7c, 0x7b, 0x70, 0x6f:
+		return i + 1, nil
+	}
+	_, i, err := readWasmULEB(b, i) // Type index, as an s33
+	return i, err
+}
+
+// skipWasmMiscInstr skips the immediates of an instruction having the
+// prefix 0xfc.
+func skipWasmMiscInstr(b []byte, i int) (int, error) {
+	op, i, err := readWasmULEB(b, i)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case op <= 7: // Saturating truncation
+		return i, nil
+	case op == 8: // memory.init
+		_, i, err = readWasmULEB(b, i)
+		return i + 1, err
+	case op == 9 || op == 13 || 15 <= op && op <= 17: // data.drop, elem.drop, table.grow/size/fill
+		_, i, err = readWasmULEB(b, i)
+		return i, err
+	case op == 10: // memory.copy
+		return i + 2, nil
+	case op == 11: // memory.fill
+		return i + 1, nil
+	case op == 12 || op == 14: // table.init, table.copy
+		return skipWasmULEBs(b, i, 2)
+	}
+	return 0, fmt.Errorf("unsupported instruction 0xfc %d", op)
+}
+
+// skipWasmVectorInstr skips the immediates of an instruction having the
+// prefix 0xfd.
+func skipWasmVectorInstr(b []byte, i int) (int, error) {
+	op, i, err := readWasmULEB(b, i)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case op <= 11 || op == 92 || op == 93: // Loads and stores
+		return skipWasmULEBs(b, i, 2)
+	case op == 12 || op == 13: // v128.const, i8x16.shuffle
+		return i + 16, nil
+	case 21 <= op && op <= 34: // Lane extraction and replacement
+		return i + 1, nil
+	case 84 <= op && op <= 91: // Lane loads and stores
+		i, err = skipWasmULEBs(b, i, 2)
+		return i + 1, err
+	case op <= 255:
+		return i, nil
+	}
+	return 0, fmt.Errorf("unsupported instruction 0xfd %d", op)
+}
+
+func skipWasmULEBs(b []byte, i int, n uint64) (int, error) {
+	var err error
+	for ; n > 0 && err == nil; n-- {
+		_, i, err = readWasmULEB(b, i)
+	}
+	return i, err
+}
+
+// readWasmULEB reads an unsigned LEB128 number. It also skips signed
+// numbers, which have the same length.
+func readWasmULEB(b []byte, i int) (uint64, int, error) {
+	var n uint64
+	for shift := 0; shift < 64; shift += 7 {
+		if i >= len(b) {
+			return 0, 0, errWasmTruncated
+		}
+		c := b[i]
+		i++
+		n |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return n, i, nil
+		}
+	}
+	return 0, 0, errors.New("invalid LEB128 number")
+}
+
+func appendWasmULEB(b []byte, n uint64) []byte {
+	for n >= 0x80 {
+		b = append(b, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(b, byte(n))
+}
+
+func appendWasmSLEB(b []byte, n int64) []byte {
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 && c&0x40 == 0 || n == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendWasmName(b []byte, name string) []byte {
+	b = appendWasmULEB(b, uint64(len(name)))
+	return append(b, name...)
+}
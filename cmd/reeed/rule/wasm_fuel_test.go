@@ -0,0 +1,216 @@
+package rule
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// testWasmModule encodes a version 1 WebAssembly module from its
+// sections.
+func testWasmModule(sections ...wasmSection) []byte {
+	b := []byte("\x00asm\x01\x00\x00\x00")
+	for _, s := range sections {
+		b = append(b, s.id)
+		b = appendWasmULEB(b, uint64(len(s.payload)))
+		b = append(b, s.payload...)
+	}
+	return b
+}
+
+// testWasmCode encodes a code section with one function body, which has
+// no locals.
+func testWasmCode(instrs ...byte) wasmSection {
+	body := append([]byte{0x00}, instrs...)
+	payload := appendWasmULEB([]byte{0x01}, uint64(len(body)))
+	return wasmSection{wasmCodeSection, append(payload, body...)}
+}
+
+func testWasmExport(name string, kind byte, index uint64) []byte {
+	b := appendWasmName(nil, name)
+	b = append(b, kind)
+	return appendWasmULEB(b, index)
+}
+
+func TestMeterWasmOutOfFuel(t *testing.T) {
+	ctx := context.Background()
+	b := testWasmModule(
+		wasmSection{1, []byte{0x01, 0x60, 0x00, 0x00}}, // Type: () -> ()
+		wasmSection{3, []byte{0x01, 0x00}},             // Function
+		wasmSection{wasmExportSection, append([]byte{0x01}, testWasmExport("run", 0x00, 0)...)},
+		testWasmCode(
+			0x03, 0x40, // loop
+			0x0c, 0x00, // br 0
+			0x0b, // end
+			0x0b, // end
+		),
+	)
+
+	metered, err := meterWasm(b, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := wazero.NewRuntime(ctx)
+	defer func() {
+		_ = r.Close(ctx)
+	}()
+	mod, err := r.Instantiate(ctx, metered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mod.ExportedFunction("run").Call(ctx)
+	if err == nil {
+		t.Fatal("expected the loop to trap")
+	}
+	fuel := mod.ExportedGlobal(wasmFuelExport)
+	if fuel == nil {
+		t.Fatalf("expected the module to export %s", wasmFuelExport)
+	}
+	if int64(fuel.Get()) >= 0 {
+		t.Errorf("expected negative fuel, got %d", int64(fuel.Get()))
+	}
+	rule := &wasmRule{path: "spam.loop.wasm"}
+	err = rule.wrapErr(ctx, mod, err)
+	if !strings.Contains(err.Error(), "out of fuel") {
+		t.Errorf("expected an out of fuel error, got %q", err)
+	}
+}
+
+func TestMeterWasmWithoutGlobalsOrExports(t *testing.T) {
+	ctx := context.Background()
+	b := testWasmModule(
+		wasmSection{1, []byte{0x01, 0x60, 0x00, 0x00}}, // Type: () -> ()
+		wasmSection{3, []byte{0x01, 0x00}},             // Function
+		wasmSection{8, []byte{0x00}},                   // Start: function 0
+		testWasmCode(
+			0x41, 0x01, // i32.const 1
+			0x1a, // drop
+			0x0b, // end
+		),
+	)
+
+	metered, err := meterWasm(b, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := wazero.NewRuntime(ctx)
+	defer func() {
+		_ = r.Close(ctx)
+	}()
+	mod, err := r.Instantiate(ctx, metered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fuel := mod.ExportedGlobal(wasmFuelExport)
+	if fuel == nil {
+		t.Fatalf("expected the module to export %s", wasmFuelExport)
+	}
+	// The start function is charged for its three instructions.
+	if int64(fuel.Get()) != 97 {
+		t.Errorf("expected 97 fuel left, got %d", int64(fuel.Get()))
+	}
+}
+
+func TestMeterWasmImportedGlobals(t *testing.T) {
+	ctx := context.Background()
+	env := testWasmModule(
+		wasmSection{wasmGlobalSection, []byte{
+			0x02,
+			0x7f, 0x00, 0x41, 0x05, 0x0b, // i32 = 5
+			0x7f, 0x00, 0x41, 0x06, 0x0b, // i32 = 6
+		}},
+		wasmSection{wasmExportSection, append(append([]byte{0x02},
+			testWasmExport("g0", 0x03, 0)...),
+			testWasmExport("g1", 0x03, 1)...)},
+	)
+	importGlobal := func(name string) []byte {
+		b := appendWasmName(nil, "env")
+		b = appendWasmName(b, name)
+		return append(b, 0x03, 0x7f, 0x00) // Global i32, immutable
+	}
+	b := testWasmModule(
+		wasmSection{1, []byte{0x01, 0x60, 0x00, 0x01, 0x7f}}, // Type: () -> i32
+		wasmSection{wasmImportSection, append(append([]byte{0x02}, importGlobal("g0")...), importGlobal("g1")...)},
+		wasmSection{3, []byte{0x01, 0x00}},                                         // Function
+		wasmSection{wasmGlobalSection, []byte{0x01, 0x7f, 0x00, 0x41, 0x07, 0x0b}}, // i32 = 7
+		wasmSection{wasmExportSection, append([]byte{0x01}, testWasmExport("get", 0x00, 0)...)},
+		testWasmCode(
+			0x23, 0x00, // global.get 0
+			0x23, 0x02, // global.get 2
+			0x6a, // i32.add
+			0x0b, // end
+		),
+	)
+
+	metered, err := meterWasm(b, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := wazero.NewRuntime(ctx)
+	defer func() {
+		_ = r.Close(ctx)
+	}()
+	_, err = r.InstantiateWithConfig(ctx, env, wazero.NewModuleConfig().WithName("env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := r.Instantiate(ctx, metered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := mod.ExportedFunction("get").Call(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0] != 12 {
+		t.Errorf("expected 12, got %d", results[0])
+	}
+	fuel := mod.ExportedGlobal(wasmFuelExport)
+	if fuel == nil {
+		t.Fatalf("expected the module to export %s", wasmFuelExport)
+	}
+	if int64(fuel.Get()) != 96 {
+		t.Errorf("expected 96 fuel left, got %d", int64(fuel.Get()))
+	}
+}
+
+func TestMeterWasmExportConflict(t *testing.T) {
+	b := testWasmModule(
+		wasmSection{1, []byte{0x01, 0x60, 0x00, 0x00}}, // Type: () -> ()
+		wasmSection{3, []byte{0x01, 0x00}},             // Function
+		wasmSection{wasmExportSection, append([]byte{0x01}, testWasmExport(wasmFuelExport, 0x00, 0)...)},
+		testWasmCode(0x0b),
+	)
+
+	_, err := meterWasm(b, 100)
+
+	if err == nil || !strings.Contains(err.Error(), "already exports") {
+		t.Errorf("expected an export conflict, got %v", err)
+	}
+}
+
+func TestMeterWasmInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"bad magic", []byte("\x00wasm\x01\x00\x00\x00")},
+		{"truncated section", append(testWasmModule(), wasmCodeSection, 0x05, 0x01)},
+		{"truncated function", testWasmModule(wasmSection{wasmCodeSection, []byte{0x01, 0x05, 0x00}})},
+		{"truncated instruction", testWasmModule(testWasmCode(0x41))},
+		{"unknown import kind", testWasmModule(wasmSection{wasmImportSection, []byte{0x01, 0x00, 0x00, 0x09}})},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := meterWasm(testCase.b, 100)
+
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package rule
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLoadWasmName(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"rules/spam.wasm", "must have the form"},
+		{"rules/.rule.wasm", "must have the form"},
+		{"rules/spam..wasm", "must have the form"},
+		{"rules/spam.foo.bar.wasm", "must have the form"},
+		{"rules/sp am.rule.wasm", "group name contains invalid character ' '"},
+		{"rules/spam.ru+le.wasm", "rule name contains invalid character '+'"},
+		{"rules/spam.rule.wasm", "no such file or directory"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			set := &GroupSet{}
+
+			err := set.LoadWasm(context.Background(), nil, testCase.path)
+
+			if err == nil || !strings.Contains(err.Error(), testCase.expected) {
+				t.Errorf("expected error containing %q, got %v", testCase.expected, err)
+			}
+		})
+	}
+}
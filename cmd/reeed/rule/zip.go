@@ -0,0 +1,143 @@
+package rule
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+
+	"github.com/dop251/goja"
+)
+
+// Limits on reading zip archives, which protect against zip bombs.
+// Only the central directory of the attachment itself is read up front.
+// Nested archives are decompressed into memory, up to zipMaxNestedSize
+// bytes, only when their entries are accessed.
+const (
+	zipMaxEntries    = 1000     // Max total entries listed per attachment
+	zipMaxDepth      = 3        // Max nesting depth, 1 is the attachment itself
+	zipMaxNestedSize = 16 << 20 // Max uncompressed size of a nested archive
+)
+
+type jsZipEntry struct {
+	file      *zip.File
+	depth     int
+	remaining *int
+
+	name           goja.Value // string
+	size           goja.Value // number
+	compressedSize goja.Value // number
+	encrypted      goja.Value // boolean
+	modified       goja.Value // Date
+	entries        goja.Value // []zip entry, or null
+}
+
+// marshalZipEntries returns the entries of the zip archive contained in
+// content, or null if content isn't a readable zip archive. The depth is
+// the nesting depth of the archive, and remaining is the number of
+// entries which may still be listed before the entry limit is reached.
+func marshalZipEntries(cont *vmContainer, content []byte, depth int, remaining *int) (goja.Value, error) {
+	if depth > zipMaxDepth {
+		return goja.Null(), nil
+	}
+	r, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return goja.Null(), nil
+	}
+	if cont.zipEntryProto == nil {
+		proto, err := jsZipEntryPrototype(cont)
+		if err != nil {
+			return nil, err
+		}
+		cont.zipEntryProto = proto
+	}
+	vm := cont.vm
+	a := make([]goja.Value, 0, len(r.File))
+	for _, file := range r.File {
+		if *remaining <= 0 {
+			break
+		}
+		*remaining--
+		e := &jsZipEntry{
+			file:      file,
+			depth:     depth,
+			remaining: remaining,
+		}
+		o := vm.ToValue(e).ToObject(vm)
+		err = o.SetPrototype(cont.zipEntryProto)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, o)
+	}
+	return vm.ToValue(a), nil
+}
+
+func jsZipEntryPrototype(cont *vmContainer) (*goja.Object, error) {
+	vm := cont.vm
+	proto := vm.NewObject()
+	err := defineCachedProperty(vm, proto, "name", func(e *jsZipEntry) (goja.Value, error) {
+		return vm.ToValue(e.file.Name), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "size", func(e *jsZipEntry) (goja.Value, error) {
+		return vm.ToValue(e.file.UncompressedSize64), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "compressedSize", func(e *jsZipEntry) (goja.Value, error) {
+		return vm.ToValue(e.file.CompressedSize64), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "encrypted", func(e *jsZipEntry) (goja.Value, error) {
+		return vm.ToValue(e.file.Flags&0x1 != 0), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "modified", func(e *jsZipEntry) (goja.Value, error) {
+		return marshalDate(vm, e.file.Modified)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = defineCachedProperty(vm, proto, "entries", func(e *jsZipEntry) (goja.Value, error) {
+		if e.depth >= zipMaxDepth {
+			return goja.Null(), nil
+		}
+		content := readNestedZip(e.file)
+		if content == nil {
+			return goja.Null(), nil
+		}
+		return marshalZipEntries(cont, content, e.depth+1, e.remaining)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto, nil
+}
+
+// readNestedZip returns the uncompressed content of file if it is a zip
+// archive within the size limit, or nil otherwise.
+func readNestedZip(file *zip.File) []byte {
+	if file.Flags&0x1 != 0 || file.UncompressedSize64 > zipMaxNestedSize || file.FileInfo().IsDir() {
+		return nil
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	// The declared size may lie, so don't trust it.
+	content, err := io.ReadAll(io.LimitReader(rc, zipMaxNestedSize+1))
+	if err != nil || len(content) > zipMaxNestedSize || sniffContentType(content) != "application/zip" {
+		return nil
+	}
+	return content
+}
@@ -1,8 +1,28 @@
 package store
 
-import "github.com/gogama/reee-evolution/daemon"
+import (
+	"sync"
+	"time"
 
+	"github.com/gogama/reee-evolution/daemon"
+)
+
+// NullStore doesn't store messages or evaluation records. It keeps
+// rule state in memory, so state works, but doesn't persist, when the
+// daemon runs without a database.
 type NullStore struct {
+	mu    sync.Mutex
+	state map[stateKey]stateValue
+}
+
+type stateKey struct {
+	namespace string
+	key       string
+}
+
+type stateValue struct {
+	value   string
+	expires time.Time
 }
 
 func (s *NullStore) GetMetadata(_ string) (daemon.Metadata, bool, error) {
@@ -13,6 +33,64 @@ func (s *NullStore) PutMessage(_ string, _ *daemon.Message) error {
 	return nil
 }
 
-func (s *NullStore) RecordEval(_ string, _ *daemon.EvalRecord) error {
+func (s *NullStore) RecordEval(_ string, r *daemon.EvalRecord) error {
+	if r.Err() != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := r.RuleLen()
+	for i := 0; i < m; i++ {
+		rr := r.Rule(i)
+		if rr.Err() != nil {
+			continue
+		}
+		n := rr.StateChangeLen()
+		for j := 0; j < n; j++ {
+			sc := rr.StateChange(j)
+			k := stateKey{sc.Namespace, sc.Key}
+			if sc.Op == daemon.StateDelete {
+				delete(s.state, k)
+				continue
+			}
+			if s.state == nil {
+				s.state = make(map[stateKey]stateValue)
+			}
+			if sc.Op == daemon.StateSet {
+				s.state[k] = stateValue{sc.Value, sc.Expires}
+				continue
+			}
+			v, ok := s.state[k]
+			if ok && !v.expires.IsZero() && !v.expires.After(sc.Time) {
+				v, ok = stateValue{}, false
+			}
+			value, err := daemon.AddState(v.value, ok, sc.Delta)
+			if err != nil {
+				// Like the SQLite store, treat a value which isn't a
+				// number as zero.
+				value, _ = daemon.AddState("", false, sc.Delta)
+			}
+			if !sc.Expires.IsZero() {
+				v.expires = sc.Expires
+			}
+			s.state[k] = stateValue{value, v.expires}
+		}
+	}
+	now := time.Now()
+	for k, v := range s.state {
+		if !v.expires.IsZero() && !v.expires.After(now) {
+			delete(s.state, k)
+		}
+	}
 	return nil
 }
+
+func (s *NullStore) GetState(namespace, key string, now time.Time) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.state[stateKey{namespace, key}]
+	if !ok || (!v.expires.IsZero() && !v.expires.After(now)) {
+		return "", false, nil
+	}
+	return v.value, true, nil
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/mail"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jhillyerd/enmime"
@@ -215,24 +217,20 @@ func (s *SQLite3Store) RecordEval(storeID string, r *daemon.EvalRecord) error {
 		}
 	}()
 
-	// Get the final result of the group evaluation if there was one.
+	// Get the final result of the group evaluation.
 	var match *bool
 	var errStr *string
-	m := r.RuleLen()
-	if m > 0 {
-		rr := r.Rule(m - 1)
-		boolValue := rr.Match()
+	if groupErr := r.Err(); groupErr == nil {
+		boolValue := r.Match()
 		match = &boolValue
-		if lastRuleErr := rr.Err(); lastRuleErr != nil {
-			match = nil
-			strValue := lastRuleErr.Error()
-			errStr = &strValue
-		}
+	} else {
+		strValue := groupErr.Error()
+		errStr = &strValue
 	}
 
 	// Insert the root group evaluation record and get back its ID.
 	var result sql.Result
-	result, err = s.stmt[putGroupEvalRecord].Exec(storeID, r.Group(),
+	result, err = tx.Stmt(s.stmt[putGroupEvalRecord]).Exec(storeID, r.Group(),
 		r.StartTime().Format(formatISO8601), r.EndTime().Format(formatISO8601), r.EndTime().Sub(r.StartTime()).Seconds(),
 		match, errStr)
 	if err != nil {
@@ -243,11 +241,13 @@ func (s *SQLite3Store) RecordEval(storeID string, r *daemon.EvalRecord) error {
 		return err
 	}
 
-	// Insert each rule evaluation record, alongside the tag changes for
-	// that rule.
+	// Insert each rule evaluation record, alongside the tag changes and
+	// state changes for that rule.
+	m := r.RuleLen()
+	var numStateChanges int
 	for i := 0; i < m; i++ {
 		rr := r.Rule(i)
-		if ruleErr := rr.Err(); err == nil {
+		if ruleErr := rr.Err(); ruleErr == nil {
 			boolValue := rr.Match()
 			match = &boolValue
 			errStr = nil
@@ -256,7 +256,7 @@ func (s *SQLite3Store) RecordEval(storeID string, r *daemon.EvalRecord) error {
 			strValue := ruleErr.Error()
 			errStr = &strValue
 		}
-		_, err = s.stmt[putRuleEvalRecord].Exec(groupEvalID, rr.Rule(),
+		_, err = tx.Stmt(s.stmt[putRuleEvalRecord]).Exec(groupEvalID, rr.Rule(),
 			rr.StartTime().Format(formatISO8601), rr.EndTime().Format(formatISO8601), rr.EndTime().Sub(rr.StartTime()).Seconds(),
 			match, errStr)
 		if err != nil {
@@ -266,11 +266,53 @@ func (s *SQLite3Store) RecordEval(storeID string, r *daemon.EvalRecord) error {
 		n := rr.TagChangeLen()
 		for j := 0; j < n; j++ {
 			tc := rr.TagChange(j)
-			_, err = s.stmt[putTagChange].Exec(storeID, tc.Key, tc.Value, tc.Time.Format(formatISO8601), r.Group(), rr.Rule())
+			_, err = tx.Stmt(s.stmt[putTagChange]).Exec(storeID, tc.Key, tc.Value, tc.Time.Format(formatISO8601), r.Group(), rr.Rule())
 			if err != nil {
 				return err
 			}
 		}
+
+		// Apply the state changes made by the rule, unless the rule
+		// or the group evaluation failed.
+		if r.Err() != nil || rr.Err() != nil {
+			continue
+		}
+		n = rr.StateChangeLen()
+		for j := 0; j < n; j++ {
+			sc := rr.StateChange(j)
+			var expireTime *int64
+			if !sc.Expires.IsZero() {
+				ms := sc.Expires.UnixMilli()
+				expireTime = &ms
+			}
+			switch sc.Op {
+			case daemon.StateSet:
+				_, err = tx.Stmt(s.stmt[putState]).Exec(sc.Namespace, sc.Key, sc.Value, sc.Time.Format(formatISO8601), expireTime)
+			case daemon.StateIncr:
+				_, err = tx.Stmt(s.stmt[incrState]).Exec(sc.Namespace, sc.Key, sc.Delta, sc.Time.Format(formatISO8601), expireTime, sc.Time.UnixMilli())
+			case daemon.StateDelete:
+				_, err = tx.Stmt(s.stmt[deleteState]).Exec(sc.Namespace, sc.Key)
+			}
+			if err != nil {
+				return err
+			}
+			numStateChanges++
+		}
+		n = rr.TrainingLen()
+		for j := 0; j < n; j++ {
+			err = s.train(tx, rr.Training(j))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Purge expired state, if any state changed.
+	if numStateChanges > 0 {
+		_, err = tx.Stmt(s.stmt[purgeState]).Exec(time.Now().UnixMilli())
+		if err != nil {
+			return err
+		}
 	}
 
 	// Commit the transaction and return.
@@ -282,6 +324,381 @@ func (s *SQLite3Store) RecordEval(storeID string, r *daemon.EvalRecord) error {
 	return nil
 }
 
+func (s *SQLite3Store) GetState(namespace, key string, now time.Time) (string, bool, error) {
+	var value string
+	err := s.stmt[getState].QueryRow(namespace, key, now.UnixMilli()).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (s *SQLite3Store) CountFrom(address string, since time.Time, exclude string) (int, error) {
+	var n int
+	err := s.stmt[countFrom].QueryRow(address, exclude, formatSince(since)).Scan(&n)
+	return n, err
+}
+
+// formatSince formats a time for comparison with insert_time. Because
+// insert_time is stored with the local UTC offset of the time it was
+// written, and with a variable number of fractional digits, history
+// queries compare and sort julianday(insert_time), which is indexed,
+// rather than the raw text.
+func formatSince(since time.Time) string {
+	return since.UTC().Format(formatISO8601)
+}
+
+func (s *SQLite3Store) FirstSeen(address string, exclude string) (time.Time, bool, error) {
+	var insertTime string
+	err := s.stmt[firstSeen].QueryRow(address, exclude).Scan(&insertTime)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	t, err := time.Parse(formatISO8601, insertTime)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t, true, nil
+}
+
+func (s *SQLite3Store) LastTags(address string, exclude string) (map[string]string, bool, error) {
+	var storeID string
+	err := s.stmt[lastFrom].QueryRow(address, exclude).Scan(&storeID)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	tags, err := s.getTags(storeID)
+	if err != nil {
+		return nil, false, err
+	}
+	return tags, true, nil
+}
+
+func (s *SQLite3Store) DomainVolume(domain string, since time.Time, exclude string) (int, error) {
+	var n int
+	err := s.stmt[domainVolume].QueryRow(domain, exclude, formatSince(since)).Scan(&n)
+	return n, err
+}
+
+func (s *SQLite3Store) GetMessage(storeID string) (daemon.StoredMessage, bool, error) {
+	rows, err := s.stmt[getMessage].Query(storeID)
+	if err != nil {
+		return daemon.StoredMessage{}, false, err
+	}
+	msgs, err := s.scanStoredMessages(rows)
+	if err != nil || len(msgs) == 0 {
+		return daemon.StoredMessage{}, false, err
+	}
+	return msgs[0], true, nil
+}
+
+func (s *SQLite3Store) GetReplies(messageID string, exclude string) ([]daemon.StoredMessage, error) {
+	rows, err := s.stmt[getReplies].Query(messageID, exclude)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanStoredMessages(rows)
+}
+
+func (s *SQLite3Store) scanStoredMessages(rows *sql.Rows) ([]daemon.StoredMessage, error) {
+	var msgs []daemon.StoredMessage
+	err := func() error {
+		defer func() {
+			_ = rows.Close()
+		}()
+		for rows.Next() {
+			var msg daemon.StoredMessage
+			var insertTime string
+			var sendTime, fromAddress, fromAlias, toAddress, subject, inReplyTo, threadTopic sql.NullString
+			err := rows.Scan(&msg.StoreID, &insertTime, &sendTime, &fromAddress, &fromAlias, &toAddress, &subject, &inReplyTo, &threadTopic)
+			if err != nil {
+				return err
+			}
+			msg.InsertTime, err = time.Parse(formatISO8601, insertTime)
+			if err != nil {
+				return err
+			}
+			if sendTime.Valid {
+				msg.SendTime, err = time.Parse(formatISO8601, sendTime.String)
+				if err != nil {
+					return err
+				}
+			}
+			msg.FromAddress = fromAddress.String
+			msg.FromName = fromAlias.String
+			msg.ToAddress = toAddress.String
+			msg.Subject = subject.String
+			msg.InReplyTo = inReplyTo.String
+			msg.ThreadTopic = threadTopic.String
+			msgs = append(msgs, msg)
+		}
+		return rows.Err()
+	}()
+	if err != nil {
+		return nil, err
+	}
+	storeIDs := make([]string, len(msgs))
+	for i := range msgs {
+		storeIDs[i] = msgs[i].StoreID
+	}
+	tags, err := s.getTagsIn(storeIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range msgs {
+		msgs[i].Tags = tags[msgs[i].StoreID]
+		if msgs[i].Tags == nil {
+			msgs[i].Tags = make(map[string]string)
+		}
+	}
+	return msgs, nil
+}
+
+func (s *SQLite3Store) GetClassifierCounts(classifier string, tokens []string) (daemon.ClassifierCounts, error) {
+	counts := daemon.ClassifierCounts{
+		Docs:   make(map[string]int),
+		Tokens: make(map[string]map[string]int),
+	}
+	rows, err := s.stmt[getClassifierDocs].Query(classifier)
+	if err != nil {
+		return counts, err
+	}
+	err = scanClassifierCounts(rows, func(label string, docs int) {
+		counts.Docs[label] = docs
+	})
+	if err != nil || len(counts.Docs) == 0 {
+		return counts, err
+	}
+
+	// Look up the tokens in batches, to stay below the SQLite limit on
+	// the number of host parameters.
+	for len(tokens) > 0 {
+		batch := tokens
+		if len(batch) > classifierTokenBatchSize {
+			batch = batch[:classifierTokenBatchSize]
+		}
+		tokens = tokens[len(batch):]
+		args := make([]any, 0, len(batch)+1)
+		args = append(args, classifier)
+		for _, token := range batch {
+			args = append(args, token)
+		}
+		query := `SELECT token, label, docs FROM classifier_token WHERE classifier = ? AND token IN (?` +
+			strings.Repeat(",?", len(batch)-1) + `)`
+		rows, err = s.db.Query(query, args...)
+		if err != nil {
+			return counts, err
+		}
+		err = func() error {
+			defer func() {
+				_ = rows.Close()
+			}()
+			for rows.Next() {
+				var token, label string
+				var docs int
+				if err := rows.Scan(&token, &label, &docs); err != nil {
+					return err
+				}
+				if counts.Tokens[token] == nil {
+					counts.Tokens[token] = make(map[string]int)
+				}
+				counts.Tokens[token][label] = docs
+			}
+			return rows.Err()
+		}()
+		if err != nil {
+			return counts, err
+		}
+	}
+	return counts, nil
+}
+
+func (s *SQLite3Store) TrainClassifiers(training []daemon.ClassifierTraining) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	for i := range training {
+		err = s.train(tx, training[i])
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	tx = nil
+	return nil
+}
+
+// train applies one classifier training within a transaction. If the
+// classifier was already trained with the message, using the same
+// label, nothing changes. If it was trained using a different label,
+// the old training is undone using the tokens it was trained with,
+// which are kept with the message.
+func (s *SQLite3Store) train(tx *sql.Tx, t daemon.ClassifierTraining) error {
+	var oldLabel, oldTokensJSON string
+	err := tx.Stmt(s.stmt[getClassifierMessage]).QueryRow(t.Classifier, t.StoreID).Scan(&oldLabel, &oldTokensJSON)
+	if err == nil && oldLabel == t.Label {
+		return nil
+	} else if err == nil {
+		var oldTokens []string
+		err = json.Unmarshal([]byte(oldTokensJSON), &oldTokens)
+		if err != nil {
+			return fmt.Errorf("invalid tokens for message %s in classifier %s: %w", t.StoreID, t.Classifier, err)
+		}
+		err = s.addClassifierCounts(tx, t.Classifier, oldLabel, oldTokens, -1)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Stmt(s.stmt[purgeClassifierLabels]).Exec(t.Classifier)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Stmt(s.stmt[purgeClassifierTokens]).Exec(t.Classifier, oldLabel)
+		if err != nil {
+			return err
+		}
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	tokens, err := json.Marshal(t.Tokens)
+	if err != nil {
+		return err
+	}
+	err = s.addClassifierCounts(tx, t.Classifier, t.Label, t.Tokens, 1)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Stmt(s.stmt[putClassifierMessage]).Exec(t.Classifier, t.StoreID, t.Label, string(tokens), t.Time.Format(formatISO8601))
+	return err
+}
+
+func (s *SQLite3Store) addClassifierCounts(tx *sql.Tx, classifier, label string, tokens []string, delta int) error {
+	_, err := tx.Stmt(s.stmt[addClassifierLabel]).Exec(classifier, label, delta)
+	if err != nil {
+		return err
+	}
+	stmt := tx.Stmt(s.stmt[addClassifierToken])
+	for _, token := range tokens {
+		_, err = stmt.Exec(classifier, token, label, delta)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLite3Store) GetLabeledMessages(tagKey string, after string, limit int) ([]daemon.LabeledMessage, error) {
+	rows, err := s.stmt[getLabeledMessages].Query(tagKey, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var msgs []daemon.LabeledMessage
+	for rows.Next() {
+		var msg daemon.LabeledMessage
+		if err = rows.Scan(&msg.StoreID, &msg.Label, &msg.FullText); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+func scanClassifierCounts(rows *sql.Rows, f func(label string, docs int)) error {
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var label string
+		var docs int
+		if err := rows.Scan(&label, &docs); err != nil {
+			return err
+		}
+		f(label, docs)
+	}
+	return rows.Err()
+}
+
+func (s *SQLite3Store) getTags(storeID string) (map[string]string, error) {
+	rows, err := s.stmt[getMetadataTags].Query(storeID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	tags := make(map[string]string)
+	for rows.Next() {
+		var k, v string
+		if err = rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		tags[k] = v
+	}
+	return tags, rows.Err()
+}
+
+// getTagsIn returns the tags of several messages, grouped by store ID.
+// Messages without tags are absent from the result.
+func (s *SQLite3Store) getTagsIn(storeIDs []string) (map[string]map[string]string, error) {
+	tags := make(map[string]map[string]string)
+	// Look up the messages in batches, to stay below the SQLite limit on
+	// the number of host parameters.
+	for len(storeIDs) > 0 {
+		batch := storeIDs
+		if len(batch) > tagBatchSize {
+			batch = batch[:tagBatchSize]
+		}
+		storeIDs = storeIDs[len(batch):]
+		args := make([]any, len(batch))
+		for i, storeID := range batch {
+			args[i] = storeID
+		}
+		query := `SELECT message_id, "key", "value" FROM tag WHERE "value" IS NOT NULL AND message_id IN (?` +
+			strings.Repeat(",?", len(batch)-1) + `)`
+		rows, err := s.db.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		err = func() error {
+			defer func() {
+				_ = rows.Close()
+			}()
+			for rows.Next() {
+				var storeID, k, v string
+				if err := rows.Scan(&storeID, &k, &v); err != nil {
+					return err
+				}
+				if tags[storeID] == nil {
+					tags[storeID] = make(map[string]string)
+				}
+				tags[storeID][k] = v
+			}
+			return rows.Err()
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
 func initSchema(ctx context.Context, conn *sql.Conn) error {
 	_, err := conn.ExecContext(ctx, `
 CREATE TABLE IF NOT EXISTS message(
@@ -356,6 +773,55 @@ CREATE TABLE IF NOT EXISTS rule_eval(
 
 CREATE INDEX IF NOT EXISTS i_rule_eval_on_group_eval_id_id
           ON rule_eval(group_eval_id, id);
+
+CREATE TABLE IF NOT EXISTS state(
+	namespace		TEXT	NOT NULL,
+	"key"			TEXT	NOT NULL,
+	"value"			TEXT	NOT NULL,
+	update_time		TEXT	NOT NULL,
+	expire_time		INTEGER,
+
+	PRIMARY KEY(namespace, "key")
+);
+
+CREATE INDEX IF NOT EXISTS i_state_on_expire_time
+          ON state(expire_time);
+
+CREATE INDEX IF NOT EXISTS i_message_on_from_address
+          ON message(from_address COLLATE NOCASE, julianday(insert_time));
+
+CREATE INDEX IF NOT EXISTS i_message_on_from_domain
+          ON message(lower(substr(from_address, instr(from_address, '@') + 1)), julianday(insert_time));
+
+CREATE INDEX IF NOT EXISTS i_message_on_in_reply_to_id
+          ON message(in_reply_to_id);
+
+CREATE TABLE IF NOT EXISTS classifier_label(
+	classifier		TEXT	NOT NULL,
+	label			TEXT	NOT NULL,
+	docs			INTEGER	NOT NULL,
+
+	PRIMARY KEY(classifier, label)
+);
+
+CREATE TABLE IF NOT EXISTS classifier_token(
+	classifier		TEXT	NOT NULL,
+	token			TEXT	NOT NULL,
+	label			TEXT	NOT NULL,
+	docs			INTEGER	NOT NULL,
+
+	PRIMARY KEY(classifier, token, label)
+);
+
+CREATE TABLE IF NOT EXISTS classifier_message(
+	classifier		TEXT	NOT NULL,
+	message_id		TEXT	NOT NULL,
+	label			TEXT	NOT NULL,
+	tokens			TEXT	NOT NULL,
+	train_time		TEXT	NOT NULL,
+
+	PRIMARY KEY(classifier, message_id)
+);
 `)
 	return err
 }
@@ -367,9 +833,36 @@ const (
 	putGroupEvalRecord
 	putRuleEvalRecord
 	putTagChange
+	getState
+	putState
+	incrState
+	deleteState
+	purgeState
+	countFrom
+	firstSeen
+	lastFrom
+	domainVolume
+	getMessage
+	getReplies
+	getClassifierDocs
+	getClassifierMessage
+	putClassifierMessage
+	addClassifierLabel
+	addClassifierToken
+	purgeClassifierLabels
+	purgeClassifierTokens
+	getLabeledMessages
 	numStmt
 
 	formatISO8601 = "2006-01-02T15:04:05.999Z07:00"
+
+	// classifierTokenBatchSize is the maximum number of tokens looked
+	// up by one query.
+	classifierTokenBatchSize = 500
+
+	// tagBatchSize is the maximum number of messages whose tags are
+	// looked up by one query.
+	tagBatchSize = 500
 )
 
 var (
@@ -394,6 +887,58 @@ var (
     		  VALUES (:message_id, :key, :value, :time, :group, :rule)
     		      ON CONFLICT(message_id, "key") DO
     	  UPDATE SET "value" = :value, update_time = :time, update_group = :group, update_rule = :rule`,
+		`SELECT "value" FROM state
+		  WHERE namespace = :namespace AND "key" = :key AND (expire_time IS NULL OR expire_time > :now)`,
+		`INSERT INTO state(namespace, "key", "value", update_time, expire_time)
+			  VALUES (:namespace, :key, :value, :update_time, :expire_time)
+			      ON CONFLICT(namespace, "key") DO
+		  UPDATE SET "value" = :value, update_time = :update_time, expire_time = :expire_time`,
+		`INSERT INTO state(namespace, "key", "value", update_time, expire_time)
+			  VALUES (:namespace, :key, :delta, :update_time, :expire_time)
+			      ON CONFLICT(namespace, "key") DO
+		  UPDATE SET "value" = CASE WHEN expire_time <= :now THEN :delta ELSE "value" + :delta END,
+		             update_time = :update_time,
+		             expire_time = CASE WHEN :expire_time IS NOT NULL THEN :expire_time
+		                                WHEN expire_time <= :now THEN NULL
+		                                ELSE expire_time END`,
+		`DELETE FROM state WHERE namespace = :namespace AND "key" = :key`,
+		`DELETE FROM state WHERE expire_time <= :now`,
+		`SELECT count(*) FROM message
+		  WHERE from_address = :address COLLATE NOCASE AND id <> :exclude
+		    AND julianday(insert_time) >= julianday(:since)`,
+		`SELECT insert_time FROM message
+		  WHERE from_address = :address COLLATE NOCASE AND id <> :exclude
+		  ORDER BY julianday(insert_time) LIMIT 1`,
+		`SELECT id FROM message
+		  WHERE from_address = :address COLLATE NOCASE AND id <> :exclude
+		  ORDER BY julianday(insert_time) DESC LIMIT 1`,
+		`SELECT count(*) FROM message
+		  WHERE lower(substr(from_address, instr(from_address, '@') + 1)) = lower(:domain) AND id <> :exclude
+		    AND julianday(insert_time) >= julianday(:since)`,
+		`SELECT id, insert_time, send_time, from_address, from_alias, to_address, subject, in_reply_to_id, thread_topic
+		   FROM message WHERE id = :id`,
+		`SELECT id, insert_time, send_time, from_address, from_alias, to_address, subject, in_reply_to_id, thread_topic
+		   FROM message WHERE in_reply_to_id = :message_id AND id <> :exclude`,
+		`SELECT label, docs FROM classifier_label WHERE classifier = :classifier`,
+		`SELECT label, tokens FROM classifier_message WHERE classifier = :classifier AND message_id = :message_id`,
+		`INSERT INTO classifier_message(classifier, message_id, label, tokens, train_time)
+			  VALUES (:classifier, :message_id, :label, :tokens, :time)
+			      ON CONFLICT(classifier, message_id) DO
+		  UPDATE SET label = :label, tokens = :tokens, train_time = :time`,
+		`INSERT INTO classifier_label(classifier, label, docs)
+			  VALUES (:classifier, :label, :delta)
+			      ON CONFLICT(classifier, label) DO
+		  UPDATE SET docs = docs + :delta`,
+		`INSERT INTO classifier_token(classifier, token, label, docs)
+			  VALUES (:classifier, :token, :label, :delta)
+			      ON CONFLICT(classifier, token, label) DO
+		  UPDATE SET docs = docs + :delta`,
+		`DELETE FROM classifier_label WHERE classifier = :classifier AND docs <= 0`,
+		`DELETE FROM classifier_token WHERE classifier = :classifier AND label = :label AND docs <= 0`,
+		`SELECT m.id, t."value", m.full_text FROM message m JOIN tag t ON t.message_id = m.id
+		  WHERE t."key" = :key AND t."value" IS NOT NULL AND m.id > :after
+		    AND m.is_sampled AND m.full_text IS NOT NULL
+		  ORDER BY m.id LIMIT :limit`,
 	}
 )
 
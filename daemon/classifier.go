@@ -0,0 +1,197 @@
+package daemon
+
+import (
+	"errors"
+	"math"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ClassifierStore is implemented by message stores which can persist
+// naive Bayes classifiers. Training done while evaluating a message is
+// buffered on the rule evaluation records and applied by RecordEval,
+// in the same transaction as the rest of the evaluation record.
+type ClassifierStore interface {
+	// GetClassifierCounts returns the document counts of a classifier
+	// for each of its labels, and for each of the given tokens which it
+	// has seen.
+	GetClassifierCounts(classifier string, tokens []string) (ClassifierCounts, error)
+	// TrainClassifiers applies a batch of training.
+	TrainClassifiers(training []ClassifierTraining) error
+	// GetLabeledMessages returns a page of up to limit sampled messages
+	// having a tag, whose tag values are used as labels. Messages are
+	// returned in store ID order, starting after the store ID after. An
+	// empty after starts with the first message.
+	GetLabeledMessages(tagKey string, after string, limit int) ([]LabeledMessage, error)
+}
+
+// LabeledMessage is a sampled message used to train a classifier.
+type LabeledMessage struct {
+	StoreID  string
+	Label    string
+	FullText []byte
+}
+
+// Classifier gives a rule access to the naive Bayes classifiers in the
+// message store. It is implemented by RuleEvalRecord.
+type Classifier interface {
+	GetClassifierCounts(classifier string, tokens []string) (ClassifierCounts, error)
+	// TrainClassifier trains a classifier with the tokens of the
+	// message being evaluated. The training takes effect if the
+	// evaluation succeeds.
+	TrainClassifier(classifier, label string, tokens []string) error
+}
+
+// ClassifierTraining trains a classifier with one message. If the
+// classifier was already trained with the message, using a different
+// label, the previous training is undone first.
+type ClassifierTraining struct {
+	Time       time.Time
+	Classifier string
+	StoreID    string
+	Label      string
+	Tokens     []string // Distinct tokens of the message
+}
+
+// ClassifierCounts holds the number of documents used to train a
+// classifier with each label, and the number of those documents which
+// contain each token.
+type ClassifierCounts struct {
+	Docs   map[string]int            // Label -> documents
+	Tokens map[string]map[string]int // Token -> label -> documents
+}
+
+var errNoClassifierStore = errors.New("daemon: message store does not support classifiers")
+
+func (rec *RuleEvalRecord) GetClassifierCounts(classifier string, tokens []string) (ClassifierCounts, error) {
+	if rec.evalRecord.classifier == nil {
+		return ClassifierCounts{}, errNoClassifierStore
+	}
+	return rec.evalRecord.classifier.GetClassifierCounts(classifier, tokens)
+}
+
+func (rec *RuleEvalRecord) TrainClassifier(classifier, label string, tokens []string) error {
+	if rec.evalRecord.classifier == nil {
+		return errNoClassifierStore
+	}
+	rec.training = append(rec.training, ClassifierTraining{
+		Time:       time.Now(),
+		Classifier: classifier,
+		StoreID:    rec.evalRecord.storeID,
+		Label:      label,
+		Tokens:     tokens,
+	})
+	return nil
+}
+
+func (rec *RuleEvalRecord) TrainingLen() int {
+	return len(rec.training)
+}
+
+func (rec *RuleEvalRecord) Training(i int) ClassifierTraining {
+	return rec.training[i]
+}
+
+// Score returns the probability of each label given the tokens of a
+// message. Tokens the classifier has never seen are ignored. The result
+// is empty if the classifier hasn't been trained.
+func (c *ClassifierCounts) Score(tokens []string) map[string]float64 {
+	var total int
+	for _, docs := range c.Docs {
+		total += docs
+	}
+	scores := make(map[string]float64, len(c.Docs))
+	if total == 0 {
+		return scores
+	}
+	maxLog := math.Inf(-1)
+	for label, docs := range c.Docs {
+		logP := math.Log(float64(docs) / float64(total))
+		for _, token := range tokens {
+			counts, ok := c.Tokens[token]
+			if !ok {
+				continue
+			}
+			logP += math.Log(float64(counts[label]+1) / float64(docs+2))
+		}
+		scores[label] = logP
+		if logP > maxLog {
+			maxLog = logP
+		}
+	}
+	var sum float64
+	for label, logP := range scores {
+		p := math.Exp(logP - maxLog)
+		scores[label] = p
+		sum += p
+	}
+	for label := range scores {
+		scores[label] /= sum
+	}
+	return scores
+}
+
+const (
+	// classifierMaxTokens is the maximum number of distinct tokens
+	// taken from one message.
+	classifierMaxTokens = 2000
+	// classifierMinTokenLen and classifierMaxTokenLen bound the length,
+	// in runes, of body and subject word tokens.
+	classifierMinTokenLen = 3
+	classifierMaxTokenLen = 40
+)
+
+// ClassifierTokens returns the distinct tokens of a message, in the
+// order they first appear. Words from the subject are prefixed with
+// "subject:", so they are distinct from words in the body. The domains
+// of the sender addresses are included as "from:" tokens.
+func ClassifierTokens(msg *Message) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	add := func(token string) bool {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+		return len(tokens) < classifierMaxTokens
+	}
+	e := msg.Envelope
+	for _, hdr := range []string{"From", "Sender", "Reply-To"} {
+		if addrs, err := mail.ParseAddressList(e.GetHeader(hdr)); err == nil {
+			for _, addr := range addrs {
+				if i := strings.LastIndexByte(addr.Address, '@'); i >= 0 {
+					add("from:" + strings.ToLower(addr.Address[i+1:]))
+				}
+			}
+		}
+	}
+	for _, word := range classifierWords(e.GetHeader("Subject")) {
+		if !add("subject:" + word) {
+			return tokens
+		}
+	}
+	for _, word := range classifierWords(e.Text) {
+		if !add(word) {
+			return tokens
+		}
+	}
+	return tokens
+}
+
+func classifierWords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '$'
+	})
+	n := 0
+	for _, word := range words {
+		word = strings.Trim(word, "'")
+		if l := utf8.RuneCountInString(word); l >= classifierMinTokenLen && l <= classifierMaxTokenLen {
+			words[n] = word
+			n++
+		}
+	}
+	return words[:n]
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"math/rand"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,18 +25,22 @@ import (
 type Daemon struct {
 	Listener  net.Listener
 	Logger    log.Printer
-	Groups    map[string][]Rule
+	Groups    map[string]Group
 	Cache     MessageCache
 	Store     MessageStore
 	SampleSrc rand.Source
 	SamplePct float64
 
-	lock      sync.RWMutex
-	ctx       context.Context
-	cancel    context.CancelFunc
-	numConns  atomic.Int64
-	closeOnce sync.Once
-	closeErr  error
+	QuarantineAfter int
+	QuarantineFor   time.Duration
+
+	lock       sync.RWMutex
+	quarantine quarantine
+	ctx        context.Context
+	cancel     context.CancelFunc
+	numConns   atomic.Int64
+	closeOnce  sync.Once
+	closeErr   error
 }
 
 var ErrStopped = errors.New("daemon: stopped")
@@ -141,6 +146,10 @@ func (d *Daemon) handle(connID uint64, conn net.Conn) {
 		data, err = handleList(&ctx)
 	case protocol.EvalCommandType:
 		data, err = handleEval(&ctx)
+	case protocol.ResetCommandType:
+		data, err = handleReset(&ctx)
+	case protocol.TrainCommandType:
+		data, err = handleTrain(&ctx)
 	default:
 		panic(fmt.Sprintf("daemon: unhandled command type: %d", cmd.Type))
 	}
@@ -179,12 +188,17 @@ func handleList(ctx *cmdContext) ([]byte, error) {
 
 	var b bytes.Buffer
 	var n int
-	for group, rules := range ctx.d.Groups {
+	for group, grp := range ctx.d.Groups {
 		_, _ = b.Write([]byte(group))
-		for _, r := range rules {
+		for _, r := range grp.Rules {
 			n++
 			_ = b.WriteByte(' ')
 			_, _ = b.WriteString(r.String())
+			if until := ctx.d.quarantinedUntil(group, r.String()); !until.IsZero() {
+				_, _ = b.WriteString("[quarantined:")
+				_, _ = b.WriteString(until.Format(time.RFC3339))
+				_ = b.WriteByte(']')
+			}
 		}
 		b.WriteByte('\n')
 	}
@@ -194,6 +208,99 @@ func handleList(ctx *cmdContext) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+func handleReset(ctx *cmdContext) ([]byte, error) {
+	g, r, _ := strings.Cut(ctx.args, " ")
+	if strings.IndexByte(r, ' ') >= 0 {
+		return nil, fmt.Errorf("%s command args format must be [<group> [<rule>]] but got %q", protocol.ResetCommandType, ctx.args)
+	}
+
+	released := ctx.d.resetQuarantine(g, r)
+	var b bytes.Buffer
+	for _, key := range released {
+		_, _ = b.WriteString(key.group)
+		_ = b.WriteByte(' ')
+		_, _ = b.WriteString(key.rule)
+		_ = b.WriteByte('\n')
+	}
+
+	ctx.Verbose("released %d rules from quarantine.", len(released))
+
+	return b.Bytes(), nil
+}
+
+// trainBatchSize is the number of messages trained per transaction by
+// the train command.
+const trainBatchSize = 100
+
+func handleTrain(ctx *cmdContext) ([]byte, error) {
+	classifier, key, _ := strings.Cut(ctx.args, " ")
+	if classifier == "" || key == "" || strings.IndexByte(key, ' ') >= 0 {
+		return nil, fmt.Errorf("%s command args format must be <classifier> <tag-key> but got %q", protocol.TrainCommandType, ctx.args)
+	}
+	store, ok := ctx.d.Store.(ClassifierStore)
+	if !ok {
+		return nil, errNoClassifierStore
+	}
+
+	// Train in pages, one transaction per page, so that neither the
+	// messages nor the training are held in memory all at once.
+	var n int
+	counts := make(map[string]int)
+	var after string
+	for {
+		if err := ctx.ctx.Err(); err != nil {
+			return nil, fmt.Errorf("trained %d messages before stopping: %w", n, err)
+		}
+		msgs, err := store.GetLabeledMessages(key, after, trainBatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("trained %d messages before failing: %w", n, err)
+		}
+		batch := make([]ClassifierTraining, 0, len(msgs))
+		for i := range msgs {
+			e, err := enmime.ReadEnvelope(bytes.NewReader(msgs[i].FullText))
+			if err != nil {
+				ctx.Verbose("skipping %s: invalid message: %s", msgs[i].StoreID, err)
+				continue
+			}
+			batch = append(batch, ClassifierTraining{
+				Time:       time.Now(),
+				Classifier: classifier,
+				StoreID:    msgs[i].StoreID,
+				Label:      msgs[i].Label,
+				Tokens:     ClassifierTokens(&Message{Envelope: e}),
+			})
+		}
+		if len(batch) > 0 {
+			if err = store.TrainClassifiers(batch); err != nil {
+				return nil, fmt.Errorf("trained %d messages before failing: %w", n, err)
+			}
+		}
+		for i := range batch {
+			counts[batch[i].Label]++
+		}
+		n += len(batch)
+		if len(msgs) < trainBatchSize {
+			break
+		}
+		after = msgs[len(msgs)-1].StoreID
+		ctx.Verbose("trained %d messages tagged with %s so far.", n, key)
+	}
+
+	var b bytes.Buffer
+	keys := make([]string, 0, len(counts))
+	for label := range counts {
+		keys = append(keys, label)
+	}
+	sort.Strings(keys)
+	for _, label := range keys {
+		_, _ = fmt.Fprintf(&b, "%s %d\n", label, counts[label])
+	}
+
+	ctx.Verbose("trained classifier %s with %d messages and %d labels.", classifier, n, len(keys))
+
+	return b.Bytes(), nil
+}
+
 const evalErrPrefix = "args format must be <len> <group> [<rule>] but "
 
 func handleEval(ctx *cmdContext) ([]byte, error) {
@@ -215,13 +322,15 @@ func handleEval(ctx *cmdContext) ([]byte, error) {
 		g = rem
 	}
 
+	var group Group
 	var rules []Rule
 	var ok bool
 	var deferredErr error
 
-	if rules, ok = ctx.d.Groups[g]; !ok {
+	if group, ok = ctx.d.Groups[g]; !ok {
 		deferredErr = fmt.Errorf("group not found: %s", g)
 	}
+	rules = group.Rules
 
 	if deferredErr == nil && r != "" {
 		for i := range rules {
@@ -289,6 +398,16 @@ func handleEval(ctx *cmdContext) ([]byte, error) {
 		startTime: time.Now(),
 		group:     g,
 		rules:     make([]*RuleEvalRecord, 0, len(rules)),
+		storeID:   storeID,
+	}
+	if state, ok := ctx.d.Store.(StateStore); ok {
+		ger.state = state
+	}
+	if history, ok := ctx.d.Store.(HistoryStore); ok {
+		ger.history = history
+	}
+	if classifier, ok := ctx.d.Store.(ClassifierStore); ok {
+		ger.classifier = classifier
 	}
 
 	var i int
@@ -296,6 +415,10 @@ func handleEval(ctx *cmdContext) ([]byte, error) {
 	var ruleEvalErr error
 	start = time.Now()
 	for ; i < len(rules); i++ {
+		if until, ok := ctx.d.quarantined(g, rules[i].String()); ok {
+			ctx.Normal("rule %s skipped: quarantined until %s.", rules[i], until.Format(time.RFC3339))
+			continue
+		}
 		rer := &RuleEvalRecord{
 			evalRecord: ger,
 			startTime:  time.Now(),
@@ -307,7 +430,16 @@ func handleEval(ctx *cmdContext) ([]byte, error) {
 		rer.match = match
 		rer.err = ruleEvalErr
 		ger.rules = append(ger.rules, rer)
-		if ruleEvalErr != nil {
+		if ctx.ctx.Err() == nil {
+			until, failures := ctx.d.recordOutcome(g, rules[i].String(), ruleEvalErr)
+			if !until.IsZero() {
+				ctx.Normal("rule %s quarantined until %s after %d consecutive errors.", rules[i], until.Format(time.RFC3339), failures)
+			}
+		}
+		if ruleEvalErr != nil && group.OnError == SkipOnError {
+			ctx.Verbose("rule %s skipped after error: %s", rules[i], ruleEvalErr)
+			ruleEvalErr = nil
+		} else if ruleEvalErr != nil {
 			ctx.Verbose("rule %s ended early with error: %s", rules[i], ruleEvalErr)
 			break
 		} else if match {
@@ -318,6 +450,8 @@ func handleEval(ctx *cmdContext) ([]byte, error) {
 		}
 	}
 	ger.endTime = time.Now()
+	ger.match = data != ""
+	ger.err = ruleEvalErr
 	elapsed = time.Since(start)
 	ctx.Verbose("evaluated %d of %d rules in %s.", i, len(ger.rules), elapsed)
 
@@ -0,0 +1,209 @@
+package daemon
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HistoryStore is implemented by message stores which can answer
+// queries about the messages they have seen. Addresses and domains are
+// compared case-insensitively. Each query ignores the message whose
+// store ID is exclude, which is the message being evaluated.
+type HistoryStore interface {
+	// CountFrom returns the number of messages from an address which
+	// were first seen at or after since.
+	CountFrom(address string, since time.Time, exclude string) (int, error)
+	// FirstSeen returns the time the first message from an address was
+	// seen.
+	FirstSeen(address string, exclude string) (t time.Time, hit bool, err error)
+	// LastTags returns the tags of the most recently seen message from
+	// an address.
+	LastTags(address string, exclude string) (tags map[string]string, hit bool, err error)
+	// DomainVolume returns the number of messages from addresses in a
+	// domain which were first seen at or after since.
+	DomainVolume(domain string, since time.Time, exclude string) (int, error)
+	// GetMessage returns a stored message by store ID.
+	GetMessage(storeID string) (msg StoredMessage, hit bool, err error)
+	// GetReplies returns the stored messages which are direct replies
+	// to a Message-ID.
+	GetReplies(messageID string, exclude string) ([]StoredMessage, error)
+}
+
+// StoredMessage is the basic information the message store keeps about
+// a message it has seen.
+type StoredMessage struct {
+	StoreID     string
+	InsertTime  time.Time
+	SendTime    time.Time // Zero if the message had no valid Date
+	FromAddress string
+	FromName    string
+	ToAddress   string
+	Subject     string
+	InReplyTo   string
+	ThreadTopic string
+	Tags        map[string]string
+}
+
+// MessageID returns the Message-ID of a stored message, or the empty
+// string if it didn't have one.
+func (m *StoredMessage) MessageID() string {
+	if strings.HasPrefix(m.StoreID, messageIDStorePrefix) {
+		return m.StoreID[len(messageIDStorePrefix):]
+	}
+	return ""
+}
+
+// History answers queries about the messages seen before the message
+// being evaluated. It is implemented by RuleEvalRecord.
+type History interface {
+	CountFrom(address string, since time.Time) (int, error)
+	FirstSeen(address string) (t time.Time, hit bool, err error)
+	LastTags(address string) (tags map[string]string, hit bool, err error)
+	DomainVolume(domain string, since time.Time) (int, error)
+	// Parent returns the stored message the message being evaluated
+	// replies to.
+	Parent() (msg StoredMessage, hit bool, err error)
+	// Thread returns the stored messages in the same thread as the
+	// message being evaluated, oldest first.
+	Thread() ([]StoredMessage, error)
+}
+
+// threadMax is the maximum number of stored messages returned by
+// Thread.
+const threadMax = 500
+
+var errNoHistoryStore = errors.New("daemon: message store does not support history")
+
+func (rec *RuleEvalRecord) CountFrom(address string, since time.Time) (int, error) {
+	if rec.evalRecord.history == nil {
+		return 0, errNoHistoryStore
+	}
+	return rec.evalRecord.history.CountFrom(address, since, rec.evalRecord.storeID)
+}
+
+func (rec *RuleEvalRecord) FirstSeen(address string) (time.Time, bool, error) {
+	if rec.evalRecord.history == nil {
+		return time.Time{}, false, errNoHistoryStore
+	}
+	return rec.evalRecord.history.FirstSeen(address, rec.evalRecord.storeID)
+}
+
+func (rec *RuleEvalRecord) LastTags(address string) (map[string]string, bool, error) {
+	if rec.evalRecord.history == nil {
+		return nil, false, errNoHistoryStore
+	}
+	return rec.evalRecord.history.LastTags(address, rec.evalRecord.storeID)
+}
+
+func (rec *RuleEvalRecord) DomainVolume(domain string, since time.Time) (int, error) {
+	if rec.evalRecord.history == nil {
+		return 0, errNoHistoryStore
+	}
+	return rec.evalRecord.history.DomainVolume(domain, since, rec.evalRecord.storeID)
+}
+
+// Parent looks up the message IDs in the In-Reply-To header, and then
+// in the References header, from the closest ancestor to the root, and
+// returns the first one which is in the store. Without a message store
+// which supports history, there is no parent.
+func (rec *RuleEvalRecord) Parent() (StoredMessage, bool, error) {
+	ger := rec.evalRecord
+	if ger.history == nil {
+		return StoredMessage{}, false, nil
+	}
+	for _, id := range ancestorIDs(ger.Message.Envelope.GetHeader("In-Reply-To"), ger.Message.Envelope.GetHeader("References")) {
+		storeID := messageIDStorePrefix + id
+		if storeID == ger.storeID {
+			continue
+		}
+		msg, hit, err := ger.history.GetMessage(storeID)
+		if err != nil || hit {
+			return msg, hit, err
+		}
+	}
+	return StoredMessage{}, false, nil
+}
+
+// Thread finds the stored messages which are connected to the message
+// being evaluated by In-Reply-To links, starting from the message
+// itself and the ancestors named in its own headers. Without a message
+// store which supports history, the thread is empty.
+func (rec *RuleEvalRecord) Thread() ([]StoredMessage, error) {
+	ger := rec.evalRecord
+	if ger.history == nil {
+		return nil, nil
+	}
+	queue := ancestorIDs(ger.Message.Envelope.GetHeader("In-Reply-To"), ger.Message.Envelope.GetHeader("References"))
+	if strings.HasPrefix(ger.storeID, messageIDStorePrefix) {
+		queue = append(queue, ger.storeID[len(messageIDStorePrefix):])
+	}
+	visited := make(map[string]bool)
+	found := map[string]bool{ger.storeID: true}
+	var thread []StoredMessage
+	add := func(msg StoredMessage) {
+		if found[msg.StoreID] {
+			return
+		}
+		found[msg.StoreID] = true
+		thread = append(thread, msg)
+		if msg.InReplyTo != "" {
+			queue = append(queue, msg.InReplyTo)
+		}
+		if id := msg.MessageID(); id != "" {
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 && len(thread) < threadMax {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		msg, hit, err := ger.history.GetMessage(messageIDStorePrefix + id)
+		if err != nil {
+			return nil, err
+		} else if hit {
+			add(msg)
+		}
+		replies, err := ger.history.GetReplies(id, ger.storeID)
+		if err != nil {
+			return nil, err
+		}
+		for _, reply := range replies {
+			add(reply)
+		}
+	}
+	if len(thread) > threadMax {
+		thread = thread[:threadMax]
+	}
+	sort.SliceStable(thread, func(i, j int) bool {
+		return thread[i].sortTime().Before(thread[j].sortTime())
+	})
+	return thread, nil
+}
+
+func (m *StoredMessage) sortTime() time.Time {
+	if !m.SendTime.IsZero() {
+		return m.SendTime
+	}
+	return m.InsertTime
+}
+
+var messageIDRegexp = regexp.MustCompile(`<([^<>\s]+)>`)
+
+// ancestorIDs returns the message IDs in an In-Reply-To header followed
+// by the message IDs in a References header, closest ancestor first.
+func ancestorIDs(inReplyTo, references string) []string {
+	var ids []string
+	for _, value := range []string{inReplyTo, references} {
+		matches := messageIDRegexp.FindAllStringSubmatch(value, -1)
+		for i := len(matches) - 1; i >= 0; i-- {
+			ids = append(ids, matches[i][1])
+		}
+	}
+	return ids
+}
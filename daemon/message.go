@@ -51,11 +51,17 @@ type MessageStore interface {
 }
 
 type EvalRecord struct {
-	Message   *Message
-	group     string
-	startTime time.Time
-	endTime   time.Time
-	rules     []*RuleEvalRecord
+	Message    *Message
+	group      string
+	startTime  time.Time
+	endTime    time.Time
+	rules      []*RuleEvalRecord
+	match      bool
+	err        error
+	state      StateStore      // Nil if the message store doesn't support state
+	history    HistoryStore    // Nil if the message store doesn't support history
+	classifier ClassifierStore // Nil if the message store doesn't support classifiers
+	storeID    string
 }
 
 func (rec *EvalRecord) Group() string {
@@ -70,6 +76,19 @@ func (rec *EvalRecord) EndTime() time.Time {
 	return rec.endTime
 }
 
+// Match reports whether the group evaluation resulted in a match.
+func (rec *EvalRecord) Match() bool {
+	return rec.match
+}
+
+// Err returns the error which failed the group evaluation, if any. A
+// rule error that was skipped under the SkipOnError policy does not
+// fail the group evaluation, but is still available from the rule's
+// own evaluation record.
+func (rec *EvalRecord) Err() error {
+	return rec.err
+}
+
 func (rec *EvalRecord) RuleLen() int {
 	return len(rec.rules)
 }
@@ -79,13 +98,15 @@ func (rec *EvalRecord) Rule(i int) *RuleEvalRecord {
 }
 
 type RuleEvalRecord struct {
-	evalRecord *EvalRecord
-	rule       string
-	startTime  time.Time
-	endTime    time.Time
-	match      bool
-	err        error
-	tagChanges []TagChange
+	evalRecord   *EvalRecord
+	rule         string
+	startTime    time.Time
+	endTime      time.Time
+	match        bool
+	err          error
+	tagChanges   []TagChange
+	stateChanges []StateChange
+	training     []ClassifierTraining
 }
 
 func (rec *RuleEvalRecord) Rule() string {
@@ -164,12 +185,14 @@ type TagChange struct {
 	Value *string
 }
 
+const messageIDStorePrefix = "Message-ID:"
+
 func toStoreID(e *enmime.Envelope, md5Sum string) string {
 	id := e.GetHeader("Message-ID")
 	if id != "" {
 		addr, err := mail.ParseAddress(id)
 		if err == nil {
-			return "Message-ID:" + addr.Address
+			return messageIDStorePrefix + addr.Address
 		}
 	}
 	return "MD5-Sum:" + md5Sum
@@ -0,0 +1,106 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+type quarantine struct {
+	mu    sync.Mutex
+	rules map[quarantineKey]*quarantineState
+}
+
+type quarantineKey struct {
+	group string
+	rule  string
+}
+
+type quarantineState struct {
+	failures int
+	until    time.Time
+}
+
+// quarantined reports whether the rule is currently quarantined and,
+// if so, until when. Once the cooldown period has passed the rule is
+// eligible to run again, but its failure count is retained, so a
+// single further failure puts it straight back into quarantine.
+func (d *Daemon) quarantined(group, rule string) (until time.Time, ok bool) {
+	q := &d.quarantine
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := q.rules[quarantineKey{group, rule}]
+	if s == nil || s.until.IsZero() {
+		return
+	}
+	if time.Now().Before(s.until) {
+		return s.until, true
+	}
+	s.until = time.Time{}
+	return
+}
+
+// recordOutcome updates the consecutive failure count for the rule
+// and returns a non-zero time if the outcome caused the rule to be
+// quarantined.
+func (d *Daemon) recordOutcome(group, rule string, err error) (until time.Time, failures int) {
+	if d.QuarantineAfter <= 0 {
+		return
+	}
+	q := &d.quarantine
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := quarantineKey{group, rule}
+	s := q.rules[key]
+	if err == nil {
+		if s != nil {
+			delete(q.rules, key)
+		}
+		return
+	}
+	if s == nil {
+		if q.rules == nil {
+			q.rules = make(map[quarantineKey]*quarantineState)
+		}
+		s = &quarantineState{}
+		q.rules[key] = s
+	}
+	s.failures++
+	if s.failures >= d.QuarantineAfter {
+		s.until = time.Now().Add(d.QuarantineFor)
+		until = s.until
+	}
+	return until, s.failures
+}
+
+// quarantinedUntil returns the time until which the rule is
+// quarantined, or the zero time if it is not quarantined.
+func (d *Daemon) quarantinedUntil(group, rule string) time.Time {
+	q := &d.quarantine
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if s := q.rules[quarantineKey{group, rule}]; s != nil && time.Now().Before(s.until) {
+		return s.until
+	}
+	return time.Time{}
+}
+
+// resetQuarantine clears failure tracking for all rules matching the
+// group and rule names, where a blank name matches everything. It
+// returns the keys of the rules which were quarantined at the time of
+// the reset.
+func (d *Daemon) resetQuarantine(group, rule string) []quarantineKey {
+	q := &d.quarantine
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var released []quarantineKey
+	now := time.Now()
+	for key, s := range q.rules {
+		if (group == "" || key.group == group) && (rule == "" || key.rule == rule) {
+			if now.Before(s.until) {
+				released = append(released, key)
+			}
+			delete(q.rules, key)
+		}
+	}
+	return released
+}
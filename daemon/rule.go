@@ -18,3 +18,43 @@ type Rule interface {
 	fmt.Stringer
 	Eval(ctx context.Context, logger log.Printer, msg *Message, tagger Tagger) (match bool, err error)
 }
+
+type Group struct {
+	Rules   []Rule
+	OnError ErrorPolicy
+}
+
+// ErrorPolicy determines how a group evaluation proceeds when one of
+// its rules returns an error.
+type ErrorPolicy int
+
+const (
+	// FailOnError stops evaluating the group at the first rule error
+	// and fails the whole evaluation.
+	FailOnError ErrorPolicy = iota
+	// SkipOnError treats a rule error as a non-match and continues on
+	// to the next rule in the group.
+	SkipOnError
+)
+
+var errorPolicy = []string{
+	"fail",
+	"skip",
+}
+
+func (p ErrorPolicy) String() string {
+	if 0 <= p && int(p) < len(errorPolicy) {
+		return errorPolicy[p]
+	}
+	return fmt.Sprintf("ErrorPolicy(%d)", int(p))
+}
+
+func (p *ErrorPolicy) UnmarshalText(text []byte) error {
+	for i := range errorPolicy {
+		if errorPolicy[i] == string(text) {
+			*p = ErrorPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("daemon: invalid error policy: %q", text)
+}
@@ -0,0 +1,155 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// StateStore is implemented by message stores which can persist
+// cross-message state for rules. State changes made while evaluating a
+// message are buffered on the rule evaluation records and applied by
+// RecordEval, in the same transaction as the rest of the evaluation
+// record.
+type StateStore interface {
+	// GetState returns the value of an unexpired state key.
+	GetState(namespace, key string, now time.Time) (value string, hit bool, err error)
+}
+
+// State gives a rule read and write access to persistent state which
+// is shared across messages. Keys are grouped into namespaces, so that
+// unrelated rules don't collide.
+type State interface {
+	GetState(namespace, key string) (value string, hit bool, err error)
+	// SetState sets the value of a key. If ttl is positive, the key
+	// expires after ttl.
+	SetState(namespace, key, value string, ttl time.Duration)
+	// IncrState adds delta to the numeric value of a key, which is
+	// zero if the key is missing. The store does the addition when it
+	// records the evaluation, so concurrent increments aren't lost. If
+	// ttl is positive, the key expires after ttl, otherwise it keeps
+	// its current expiry.
+	IncrState(namespace, key string, delta float64, ttl time.Duration)
+	DeleteState(namespace, key string)
+}
+
+// StateOp is the kind of change made to a state key.
+type StateOp int
+
+const (
+	StateSet StateOp = iota
+	StateDelete
+	StateIncr
+)
+
+// StateChange is a change to a state key made by a rule evaluation.
+type StateChange struct {
+	Time      time.Time
+	Op        StateOp
+	Namespace string
+	Key       string
+	Value     string    // New value, if Op is StateSet
+	Delta     float64   // Amount added, if Op is StateIncr
+	Expires   time.Time // Zero if the key doesn't expire
+}
+
+func (c *StateChange) live(now time.Time) bool {
+	return c.Op == StateSet && (c.Expires.IsZero() || c.Expires.After(now))
+}
+
+var errNoStateStore = errors.New("daemon: message store does not support state")
+
+// GetState returns the value of a key, taking into account the changes
+// made earlier in the same group evaluation by this rule and by any
+// preceding rules which didn't fail.
+func (rec *RuleEvalRecord) GetState(namespace, key string) (value string, hit bool, err error) {
+	now := time.Now()
+	var delta float64
+	var incr bool
+	c := lastStateChange(rec.stateChanges, namespace, key, &delta, &incr)
+	ger := rec.evalRecord
+	for i := len(ger.rules) - 1; c == nil && i >= 0; i-- {
+		if ger.rules[i].err == nil {
+			c = lastStateChange(ger.rules[i].stateChanges, namespace, key, &delta, &incr)
+		}
+	}
+	if c != nil {
+		value, hit = c.Value, c.live(now)
+	} else if ger.state == nil {
+		return "", false, errNoStateStore
+	} else if value, hit, err = ger.state.GetState(namespace, key, now); err != nil {
+		return "", false, err
+	}
+	if !incr {
+		return value, hit, nil
+	}
+	value, err = AddState(value, hit, delta)
+	return value, err == nil, err
+}
+
+func (rec *RuleEvalRecord) SetState(namespace, key, value string, ttl time.Duration) {
+	c := newStateChange(StateSet, namespace, key, ttl)
+	c.Value = value
+	rec.stateChanges = append(rec.stateChanges, c)
+}
+
+func (rec *RuleEvalRecord) IncrState(namespace, key string, delta float64, ttl time.Duration) {
+	c := newStateChange(StateIncr, namespace, key, ttl)
+	c.Delta = delta
+	rec.stateChanges = append(rec.stateChanges, c)
+}
+
+func (rec *RuleEvalRecord) DeleteState(namespace, key string) {
+	rec.stateChanges = append(rec.stateChanges, newStateChange(StateDelete, namespace, key, 0))
+}
+
+func (rec *RuleEvalRecord) StateChangeLen() int {
+	return len(rec.stateChanges)
+}
+
+func (rec *RuleEvalRecord) StateChange(i int) StateChange {
+	return rec.stateChanges[i]
+}
+
+func newStateChange(op StateOp, namespace, key string, ttl time.Duration) StateChange {
+	c := StateChange{
+		Time:      time.Now(),
+		Op:        op,
+		Namespace: namespace,
+		Key:       key,
+	}
+	if ttl > 0 {
+		c.Expires = c.Time.Add(ttl)
+	}
+	return c
+}
+
+// lastStateChange returns the last change which set or deleted a key.
+// The deltas of any later increments are added to delta.
+func lastStateChange(changes []StateChange, namespace, key string, delta *float64, incr *bool) *StateChange {
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := &changes[i]
+		if c.Namespace != namespace || c.Key != key {
+			continue
+		}
+		if c.Op != StateIncr {
+			return c
+		}
+		*delta += c.Delta
+		*incr = true
+	}
+	return nil
+}
+
+// AddState adds delta to a JSON number state value. A missing value is
+// treated as zero.
+func AddState(value string, hit bool, delta float64) (string, error) {
+	var n float64
+	if hit {
+		if err := json.Unmarshal([]byte(value), &n); err != nil {
+			return "", errors.New("daemon: can't increment state: value is not a number")
+		}
+	}
+	return strconv.FormatFloat(n+delta, 'g', -1, 64), nil
+}
@@ -14,6 +14,8 @@ type CommandType int
 const (
 	EvalCommandType CommandType = iota
 	ListCommandType
+	ResetCommandType
+	TrainCommandType
 )
 
 func (t CommandType) String() string {
@@ -23,6 +25,8 @@ func (t CommandType) String() string {
 var commandType = []string{
 	"eval",
 	"list",
+	"reset",
+	"train",
 }
 
 type Command struct {
@@ -123,6 +127,10 @@ func ReadCommand(r *bufio.Reader) (cmd Command, err error) {
 		err = fmt.Errorf("protocol: read command: invalid log level [%s] in [%s]", rem[0:p], line)
 		return
 	}
+	if p+1 >= len(rem) {
+		// No arguments.
+		return
+	}
 	rem = rem[p+1 : len(rem)-1] // Truncate newline
 	// Isolate the arguments.
 	cmd.Args = string(rem)
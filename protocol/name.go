@@ -0,0 +1,23 @@
+package protocol
+
+import "fmt"
+
+// ValidateName checks that a rule, group or classifier name only
+// contains the characters [a-zA-Z0-9_-], so that it can be passed as a
+// command argument. The category names the kind of name in the error
+// message.
+func ValidateName(category, name string) error {
+	for i := range name {
+		c := name[i]
+		if 'a' <= c && c <= 'z' ||
+			'A' <= c && c <= 'Z' ||
+			'0' <= c && c <= '9' ||
+			c == '_' || c == '-' {
+			continue
+		} else {
+			return fmt.Errorf("%s contains invalid character '%c'. "+
+				"valid characters are [a-zA-Z0-9_-]", category, c)
+		}
+	}
+	return nil
+}